@@ -0,0 +1,178 @@
+package wgquick
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ipRange is an inclusive range of addresses within one address family
+type ipRange struct {
+	bits       int // 32 for IPv4, 128 for IPv6
+	start, end *big.Int
+}
+
+func netToRange(n net.IPNet) (ipRange, error) {
+	ones, bits := n.Mask.Size()
+	if bits == 0 {
+		return ipRange{}, fmt.Errorf("non canonical mask for %v", n.String())
+	}
+	ip := n.IP.To16()
+	if bits == net.IPv4len*8 {
+		ip = n.IP.To4()
+	}
+	if ip == nil {
+		return ipRange{}, fmt.Errorf("ip and mask family mismatch for %v", n.String())
+	}
+	start := new(big.Int).SetBytes(ip.Mask(n.Mask))
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	end := new(big.Int).Sub(new(big.Int).Add(start, size), big.NewInt(1))
+	return ipRange{bits: bits, start: start, end: end}, nil
+}
+
+func rangeToNets(r ipRange) []net.IPNet {
+	var nets []net.IPNet
+	one := big.NewInt(1)
+	start := new(big.Int).Set(r.start)
+	for start.Cmp(r.end) <= 0 {
+		hostBits := r.bits
+		if start.Sign() != 0 {
+			hostBits = int(start.TrailingZeroBits())
+		}
+		for hostBits > 0 {
+			last := new(big.Int).Lsh(one, uint(hostBits))
+			last.Add(last, start).Sub(last, one)
+			if last.Cmp(r.end) <= 0 {
+				break
+			}
+			hostBits--
+		}
+
+		ip := make(net.IP, r.bits/8)
+		b := start.Bytes()
+		copy(ip[len(ip)-len(b):], b)
+		nets = append(nets, net.IPNet{IP: ip, Mask: net.CIDRMask(r.bits-hostBits, r.bits)})
+
+		start.Add(start, new(big.Int).Lsh(one, uint(hostBits)))
+	}
+	return nets
+}
+
+// mergeRanges sorts ranges and merges the overlapping and adjacent ones. IPv4 sorts before IPv6
+func mergeRanges(ranges []ipRange) []ipRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].bits != ranges[j].bits {
+			return ranges[i].bits < ranges[j].bits
+		}
+		return ranges[i].start.Cmp(ranges[j].start) < 0
+	})
+
+	var merged []ipRange
+	for _, r := range ranges {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			next := new(big.Int).Add(last.end, big.NewInt(1))
+			if last.bits == r.bits && r.start.Cmp(next) <= 0 {
+				if r.end.Cmp(last.end) > 0 {
+					last.end = r.end
+				}
+				continue
+			}
+		}
+		merged = append(merged, ipRange{bits: r.bits, start: r.start, end: r.end})
+	}
+	return merged
+}
+
+func toRanges(nets []net.IPNet) []ipRange {
+	ranges := make([]ipRange, 0, len(nets))
+	for _, n := range nets {
+		r, err := netToRange(n)
+		if err != nil {
+			continue
+		}
+		ranges = append(ranges, r)
+	}
+	return mergeRanges(ranges)
+}
+
+func fromRanges(ranges []ipRange) []net.IPNet {
+	var nets []net.IPNet
+	for _, r := range ranges {
+		nets = append(nets, rangeToNets(r)...)
+	}
+	return nets
+}
+
+// AggregateCIDRs returns the minimal list of prefixes covering exactly the same addresses as nets.
+// Overlapping and adjacent prefixes are merged. IPv4 prefixes are returned before IPv6 ones, each sorted by address
+func AggregateCIDRs(nets []net.IPNet) []net.IPNet {
+	return fromRanges(toRanges(nets))
+}
+
+// UnionCIDRs returns the minimal list of prefixes covering every address in any of the given sets
+func UnionCIDRs(sets ...[]net.IPNet) []net.IPNet {
+	var all []net.IPNet
+	for _, set := range sets {
+		all = append(all, set...)
+	}
+	return AggregateCIDRs(all)
+}
+
+// SubtractCIDRs returns the minimal list of prefixes covering addresses in from, but not in exclude
+func SubtractCIDRs(from, exclude []net.IPNet) []net.IPNet {
+	excluded := toRanges(exclude)
+	var result []ipRange
+	for _, r := range toRanges(from) {
+		start := new(big.Int).Set(r.start)
+		for _, ex := range excluded {
+			if ex.bits != r.bits || ex.end.Cmp(start) < 0 {
+				continue
+			}
+			if ex.start.Cmp(r.end) > 0 {
+				break
+			}
+			if ex.start.Cmp(start) > 0 {
+				result = append(result, ipRange{bits: r.bits, start: start, end: new(big.Int).Sub(ex.start, big.NewInt(1))})
+			}
+			start = new(big.Int).Add(ex.end, big.NewInt(1))
+		}
+		if start.Cmp(r.end) <= 0 {
+			result = append(result, ipRange{bits: r.bits, start: start, end: r.end})
+		}
+	}
+	return fromRanges(result)
+}
+
+// IntersectCIDRs returns the minimal list of prefixes covering addresses present in both a and b
+func IntersectCIDRs(a, b []net.IPNet) []net.IPNet {
+	return SubtractCIDRs(a, SubtractCIDRs(a, b))
+}
+
+// ParseCIDRs parses comma separated list of CIDR prefixes, e.g. "10.0.0.0/8, fd00::/8"
+func ParseCIDRs(s string) ([]net.IPNet, error) {
+	var nets []net.IPNet
+	for _, addr := range strings.Split(s, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		ip, cidr, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %v", addr, err)
+		}
+		nets = append(nets, net.IPNet{IP: ip, Mask: cidr.Mask})
+	}
+	return nets, nil
+}
+
+// FormatCIDRs formats prefixes as comma separated list, the format used by AllowedIPs
+func FormatCIDRs(nets []net.IPNet) string {
+	strs := make([]string, len(nets))
+	for i, n := range nets {
+		strs[i] = n.String()
+	}
+	return strings.Join(strs, ", ")
+}
@@ -0,0 +1,99 @@
+package wgquick
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubtractCIDRs(t *testing.T) {
+	tests := map[string]struct {
+		from, exclude, want string
+	}{
+		"rfc1918": {
+			from:    "0.0.0.0/0",
+			exclude: "10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16",
+			want:    "0.0.0.0/5, 8.0.0.0/7, 11.0.0.0/8, 12.0.0.0/6, 16.0.0.0/4, 32.0.0.0/3, 64.0.0.0/2, 128.0.0.0/3, 160.0.0.0/5, 168.0.0.0/6, 172.0.0.0/12, 172.32.0.0/11, 172.64.0.0/10, 172.128.0.0/9, 173.0.0.0/8, 174.0.0.0/7, 176.0.0.0/4, 192.0.0.0/9, 192.128.0.0/11, 192.160.0.0/13, 192.169.0.0/16, 192.170.0.0/15, 192.172.0.0/14, 192.176.0.0/12, 192.192.0.0/10, 193.0.0.0/8, 194.0.0.0/7, 196.0.0.0/6, 200.0.0.0/5, 208.0.0.0/4, 224.0.0.0/3",
+		},
+		"nothing excluded": {
+			from: "10.0.0.0/8",
+			want: "10.0.0.0/8",
+		},
+		"everything excluded": {
+			from:    "10.1.0.0/16",
+			exclude: "10.0.0.0/8",
+			want:    "",
+		},
+		"v6": {
+			from:    "::/0",
+			exclude: "::/1",
+			want:    "8000::/1",
+		},
+		"families are independent": {
+			from:    "10.0.0.0/24, fd00::/64",
+			exclude: "10.0.0.0/25, ::/0",
+			want:    "10.0.0.128/25",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			from, err := ParseCIDRs(tt.from)
+			require.NoError(t, err)
+			exclude, err := ParseCIDRs(tt.exclude)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, FormatCIDRs(SubtractCIDRs(from, exclude)))
+		})
+	}
+}
+
+func TestAggregateCIDRs(t *testing.T) {
+	tests := map[string]struct {
+		nets, want string
+	}{
+		"adjacent":   {nets: "10.0.0.0/25, 10.0.0.128/25", want: "10.0.0.0/24"},
+		"contained":  {nets: "10.0.0.0/8, 10.1.2.3/32", want: "10.0.0.0/8"},
+		"host bits":  {nets: "10.192.124.1/24", want: "10.192.124.0/24"},
+		"unaligned":  {nets: "10.0.0.1/32, 10.0.0.2/31", want: "10.0.0.1/32, 10.0.0.2/31"},
+		"mixed":      {nets: "fd00::/65, fd00::8000:0:0:0/65, 10.0.0.0/8", want: "10.0.0.0/8, fd00::/64"},
+		"everything": {nets: "0.0.0.0/1, 128.0.0.0/1, ::/1, 8000::/1", want: "0.0.0.0/0, ::/0"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			nets, err := ParseCIDRs(tt.nets)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, FormatCIDRs(AggregateCIDRs(nets)))
+		})
+	}
+}
+
+func TestExcludedIPs(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(`[Interface]
+PrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+
+[Peer]
+PublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+ExcludedIPs = 128.0.0.0/1
+AllowedIPs = 0.0.0.0/0
+`)))
+	// the directive is kept as written, it's expanded only when applied
+	assert.Equal(t, "0.0.0.0/0", FormatCIDRs(c.Peers[0].AllowedIPs))
+	assert.Equal(t, "128.0.0.0/1", FormatCIDRs(c.PeerOptions[c.Peers[0].PublicKey].ExcludedIPs))
+	assert.Equal(t, "0.0.0.0/1", FormatCIDRs(c.withExcludedIPs().Peers[0].AllowedIPs))
+	assert.Equal(t, "0.0.0.0/0", FormatCIDRs(c.Peers[0].AllowedIPs), "original untouched")
+	assert.Contains(t, c.String(), "AllowedIPs = 0.0.0.0/0\nExcludedIPs = 128.0.0.0/1\n")
+
+	for _, format := range []Format{FormatINI, FormatJSON, FormatYAML} {
+		b, err := c.Encode(format)
+		require.NoError(t, err)
+		decoded, err := DecodeConfig(format, "wg0", b)
+		require.NoError(t, err, format)
+		assert.Equal(t, c.PeerOptions, decoded.PeerOptions, format)
+		assert.Equal(t, c.Peers[0].AllowedIPs, decoded.Peers[0].AllowedIPs, format)
+	}
+
+	plain := &Config{}
+	require.NoError(t, plain.UnmarshalText([]byte("[Peer]\nPublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=\nAllowedIPs = 0.0.0.0/0\n")))
+	assert.True(t, plain == plain.withExcludedIPs(), "no copy without ExcludedIPs")
+}
@@ -0,0 +1,161 @@
+package wgquick
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ClientOptions configure the client created by AddClient
+type ClientOptions struct {
+	// Name of the client, written as comment above its [Peer] section in the server config
+	Name string
+	// Endpoint is the server address as seen by the client, host or host:port. The server ListenPort is used if port is missing
+	Endpoint string
+	// AllowedIPs routed by the client through the tunnel. Defaults to the server Address subnets
+	AllowedIPs []net.IPNet
+	// DNS servers for the client
+	DNS []net.IP
+	// PresharedKey generates PSK for the peer pair
+	PresharedKey bool
+	// PersistentKeepalive for the client, useful behind NAT. Zero disables it
+	PersistentKeepalive time.Duration
+}
+
+// AddClient creates a new client of the server interface: generates its keys, allocates its tunnel address from the server
+// Address subnets, appends the [Peer] to the server config file at path and syncs the interface. Returns the client config
+func AddClient(path string, iface string, opts ClientOptions, logger logrus.FieldLogger) (*Config, error) {
+	log := logger.WithField("iface", iface)
+	if opts.Endpoint == "" {
+		return nil, fmt.Errorf("missing server endpoint")
+	}
+	// the name becomes a comment line of the server config, a newline would start directives of its own
+	if strings.IndexFunc(opts.Name, unicode.IsControl) >= 0 {
+		return nil, fmt.Errorf("client name %q contains control characters", opts.Name)
+	}
+
+	// the lock covers reading the config too, so concurrent AddClient calls don't allocate the same address
+	lock, err := lockInterface(iface, "add-client", DefaultLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	server, err := ReadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if server.PrivateKey == nil {
+		return nil, fmt.Errorf("server config has no private key")
+	}
+	endpoint, err := clientEndpoint(opts.Endpoint, server.ListenPort)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := NewIPAM(server).Allocate()
+	if err != nil {
+		return nil, err
+	}
+
+	clientKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	serverPeer := wgtypes.PeerConfig{PublicKey: clientKey.PublicKey()}
+	for _, addr := range addrs {
+		serverPeer.AllowedIPs = append(serverPeer.AllowedIPs, net.IPNet{IP: addr.IP, Mask: net.CIDRMask(len(addr.IP)*8, len(addr.IP)*8)})
+	}
+	if opts.PresharedKey {
+		psk, err := wgtypes.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		serverPeer.PresharedKey = &psk
+	}
+
+	previous := *server
+	server.Peers = append(server.Peers, serverPeer)
+	if err := validate(server, log); err != nil {
+		return nil, err
+	}
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := appendPeer(path, server, opts.Name); err != nil {
+		return nil, err
+	}
+	log.WithField("peer", serverPeer.PublicKey.String()).WithField("name", opts.Name).Info("client added to the server config")
+	if err := syncLocked(server, iface, logger); err != nil {
+		// the client key is lost, don't leave its peer holding the address
+		if err := writeFile(path, original); err != nil {
+			log.WithError(err).Error("cannot remove the client from the server config")
+		} else if err := syncLocked(&previous, iface, logger); err != nil {
+			log.WithError(err).Error("cannot remove the client from the interface")
+		}
+		return nil, err
+	}
+
+	allowed := opts.AllowedIPs
+	if len(allowed) == 0 {
+		for _, addr := range server.Address {
+			allowed = append(allowed, net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask})
+		}
+	}
+	client := &Config{Address: addrs, DNS: opts.DNS}
+	client.PrivateKey = &clientKey
+	clientPeer := wgtypes.PeerConfig{
+		PublicKey:    server.PrivateKey.PublicKey(),
+		PresharedKey: serverPeer.PresharedKey,
+		Endpoint:     endpoint,
+		AllowedIPs:   allowed,
+	}
+	if opts.PersistentKeepalive > 0 {
+		clientPeer.PersistentKeepaliveInterval = &opts.PersistentKeepalive
+	}
+	client.Peers = []wgtypes.PeerConfig{clientPeer}
+	return client, nil
+}
+
+func clientEndpoint(endpoint string, listenPort *int) (*net.UDPAddr, error) {
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		if listenPort == nil {
+			return nil, fmt.Errorf("endpoint %s has no port and the server has no ListenPort", endpoint)
+		}
+		endpoint = net.JoinHostPort(endpoint, strconv.Itoa(*listenPort))
+	}
+	return net.ResolveUDPAddr("udp", endpoint)
+}
+
+// appendPeer appends the last peer of cfg to the config file, keeping the rest of the file with its comments intact.
+// Non wg-quick formats can't be appended to and are rewritten whole
+func appendPeer(path string, cfg *Config, name string) error {
+	if FormatFromPath(path) != FormatINI {
+		return WriteConfigFile(path, cfg)
+	}
+	text, err := cfg.MarshalText()
+	if err != nil {
+		return err
+	}
+	text = text[bytes.LastIndex(text, []byte("[Peer]\n")):]
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	block := "\n"
+	if name != "" {
+		block += "# " + name + "\n"
+	}
+	_, err = f.WriteString(block + string(text))
+	return err
+}
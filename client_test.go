@@ -0,0 +1,51 @@
+package wgquick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestAppendPeer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wg0.conf")
+	original := "# office server\n" + testConfigs["simple"]
+	require.NoError(t, ioutil.WriteFile(path, []byte(original), 0600))
+
+	c, err := ReadConfigFile(path)
+	require.NoError(t, err)
+	c.Peers = append(c.Peers, wgtypes.PeerConfig{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.200.100.9/32")})
+	require.NoError(t, appendPeer(path, c, "alice"))
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, original+"\n# alice\n[Peer]\nPublicKey = "+c.Peers[1].PublicKey.String()+"\nAllowedIPs = 10.200.100.9/32\n", string(b))
+	appended, err := ReadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, c.String(), appended.String())
+}
+
+func TestAddClientName(t *testing.T) {
+	_, err := AddClient("/nonexistent/wg0.conf", "wg0", ClientOptions{Endpoint: "127.0.0.1", Name: "alice\n[Peer]\nPublicKey = x"}, logrus.New())
+	assert.EqualError(t, err, `client name "alice\n[Peer]\nPublicKey = x" contains control characters`)
+}
+
+func TestClientEndpoint(t *testing.T) {
+	port := 51820
+	endpoint, err := clientEndpoint("127.0.0.1", &port)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:51820", endpoint.String())
+	endpoint, err = clientEndpoint("127.0.0.1:4500", &port)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:4500", endpoint.String())
+	_, err = clientEndpoint("127.0.0.1", nil)
+	assert.Error(t, err)
+}
@@ -1,17 +1,38 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/nmiculinic/wg-quick-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 func printHelp() {
-	fmt.Print("wg-quick [flags] [ up | down | sync ] [ config_file | interface ]\n\n")
+	fmt.Print("wg-quick [flags] [ up | down | sync | status | check | convert | qr | rotate | commit-keys | failover | healthcheck ] [ config_file | interface ]\n")
+	fmt.Print("wg-quick [flags] [ up-all | down-all | sync-all ] [ config_dir ]\n")
+	fmt.Print("wg-quick [flags] add-peer -server interface -endpoint host[:port] [ -name name ]\n")
+	fmt.Print("wg-quick [flags] [ mesh | hub-spoke ] inventory_file [ out_dir ]\n")
+	fmt.Print("wg-quick [flags] events interface\n")
+	fmt.Print("wg-quick [flags] metrics [ listen_address ]\n")
+	fmt.Print("wg-quick [flags] allowedips allowed_ips [ excluded_ips ]\n\n")
 	flag.Usage()
 	os.Exit(1)
 }
@@ -21,16 +42,79 @@ func main() {
 	verbose := flag.Bool("v", false, "verbose")
 	protocol := flag.Int("route-protocol", 0, "route protocol to use for our routes")
 	metric := flag.Int("route-metric", 0, "route metric to use for our routes")
+	exclusive := flag.Bool("exclusive", false, "delete all addresses and routes on the interface which aren't in the config, not only those we created")
+	pollInterval := flag.Duration("poll-interval", 5*time.Second, "how often events polls the device")
+	staleAfter := flag.Duration("stale-after", 3*time.Minute, "handshake age after which events reports the peer as stale")
+	userspace := flag.String("userspace", "auto", "use userspace wireguard-go: auto (when kernel lacks wireguard), never or always")
+	lockTimeout := flag.Duration("lock-timeout", wgquick.DefaultLockTimeout, "how long to wait for the interface lock")
+	format := flag.String("format", "", "config file format: ini, json, yaml, networkd (.netdev file) or networkmanager (.nmconnection file). Guessed from the file extension by default")
+	to := flag.String("to", "ini", "output format for convert: ini, json, yaml, networkd or networkmanager")
+	qrPNG := flag.String("qr-png", "", "write qr code as PNG to this file instead of the terminal")
+	strict := flag.Bool("strict", false, "qr refuses configs with hooks and other settings mobile clients don't support, instead of stripping them")
+	server := flag.String("server", "", "add-peer server config file or interface")
+	name := flag.String("name", "", "add-peer client name, written as comment into the server config")
+	endpoint := flag.String("endpoint", "", "add-peer server endpoint as seen by the client, host or host:port")
+	clientAllowedIPs := flag.String("client-allowed-ips", "", "add-peer client AllowedIPs, comma separated. Defaults to the server subnets")
+	clientDNS := flag.String("client-dns", "", "add-peer client DNS servers, comma separated")
+	psk := flag.Bool("psk", false, "add-peer generates preshared key")
+	keepalive := flag.Duration("keepalive", 0, "add-peer client PersistentKeepalive")
+	keyDir := flag.String("key-dir", "", "directory with <node>.key private keys of inventory nodes, created as needed. Default <out_dir>/keys")
+	outDir := flag.String("out-dir", ".", "directory where convert -to networkd writes <iface>.netdev and <iface>.network, and rotate writes <iface>-peer<N>.conf sections for remote peers without config in -remote-dir")
+	rotateKeys := flag.String("rotate", "key", "keys replaced by rotate, comma separated: key (interface private key), psk (peer preshared keys)")
+	window := flag.Duration("window", wgquick.DefaultRotateWindow, "rotate stages the new keys for this long before switching to them, remote configs switch at the same time; run commit-keys -wait on every side. 0 switches right away, dropping the traffic until the remote peers are updated")
+	wait := flag.Bool("wait", false, "commit-keys waits until the pending keys are due instead of committing them right away")
+	remoteDir := flag.String("remote-dir", "", "directory with *.conf configs of the remote peers, updated by rotate")
+	depends := flag.String("depends", "", "up-all, down-all and sync-all order: comma separated iface:dependency pairs, the dependency comes up first and goes down last, e.g. wg1:wg0")
 	flag.Parse()
 	args := flag.Args()
-	if len(args) != 2 {
-		printHelp()
-	}
-
 	if *verbose {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
+	if len(args) > 0 {
+		switch args[0] {
+		case "allowedips":
+			allowedIPs(args[1:])
+			return
+		case "up-all", "down-all", "sync-all":
+			manageAll(args[0], args[1:], *depends)
+			return
+		case "metrics":
+			serveMetrics(args[1:])
+			return
+		case "mesh", "hub-spoke":
+			generate(args[0], args[1:], *keyDir)
+			return
+		case "add-peer":
+			// add-peer flags may follow the command too
+			addPeerFlags := flag.NewFlagSet("add-peer", flag.ExitOnError)
+			for _, name := range []string{"server", "name", "endpoint", "client-allowed-ips", "client-dns", "psk", "keepalive"} {
+				f := flag.Lookup(name)
+				addPeerFlags.Var(f.Value, f.Name, f.Usage)
+			}
+			if err := addPeerFlags.Parse(args[1:]); err != nil || addPeerFlags.NArg() != 0 || *server == "" {
+				printHelp()
+			}
+			opts := wgquick.ClientOptions{
+				Name:                *name,
+				Endpoint:            *endpoint,
+				PresharedKey:        *psk,
+				PersistentKeepalive: *keepalive,
+			}
+			addPeer(*server, opts, *clientAllowedIPs, *clientDNS)
+			return
+		case "events":
+			if len(args) != 2 {
+				printHelp()
+			}
+			printEvents(args[1], *pollInterval, *staleAfter)
+			return
+		}
+	}
+	if len(args) != 2 {
+		printHelp()
+	}
+
 	iface := flag.Lookup("iface").Value.String()
 	log := logrus.WithField("iface", iface)
 
@@ -55,22 +139,69 @@ func main() {
 		printHelp()
 	}
 
-	b, err := ioutil.ReadFile(cfg)
-	if err != nil {
-		logrus.WithError(err).Fatalln("cannot read file")
+	switch args[0] {
+	case "check":
+		checkConfig(cfg, *format)
+		return
+	case "convert":
+		if iface == "" {
+			iface = strings.TrimSuffix(filepath.Base(cfg), filepath.Ext(cfg))
+		}
+		convertConfig(cfg, *format, *to, iface, *outDir)
+		return
+	case "rotate":
+		if iface == "" {
+			iface = strings.TrimSuffix(filepath.Base(cfg), filepath.Ext(cfg))
+		}
+		opts := wgquick.RotateOptions{Window: *window}
+		for _, k := range strings.Split(*rotateKeys, ",") {
+			switch strings.TrimSpace(k) {
+			case "key":
+				opts.PrivateKey = true
+			case "psk":
+				opts.PresharedKeys = true
+			default:
+				printHelp()
+			}
+		}
+		rotate(cfg, iface, opts, *remoteDir, *outDir)
+		return
+	case "commit-keys":
+		if iface == "" {
+			iface = strings.TrimSuffix(filepath.Base(cfg), filepath.Ext(cfg))
+		}
+		commitKeys(cfg, iface, *wait)
+		return
 	}
-	c := &wgquick.Config{}
-	if err := c.UnmarshalText(b); err != nil {
-		logrus.WithError(err).Fatalln("cannot parse config file")
+
+	c, err := readConfig(cfg, *format)
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot read config file")
 	}
 
 	c.RouteProtocol = *protocol
 	c.RouteMetric = *metric
+	c.LockTimeout = *lockTimeout
+	c.Exclusive = *exclusive
+	switch *userspace {
+	case "auto":
+		c.Userspace = wgquick.UserspaceAuto
+	case "never":
+		c.Userspace = wgquick.UserspaceNever
+	case "always":
+		c.Userspace = wgquick.UserspaceAlways
+	default:
+		printHelp()
+	}
 
 	switch args[0] {
 	case "up":
 		if err := wgquick.Up(c, iface, log); err != nil {
 			logrus.WithError(err).Errorln("cannot up interface")
+			return
+		}
+		if done := wgquick.UserspaceDone(iface); done != nil {
+			waitUserspace(c, iface, done, log)
 		}
 	case "down":
 		if err := wgquick.Down(c, iface, log); err != nil {
@@ -79,8 +210,561 @@ func main() {
 	case "sync":
 		if err := wgquick.Sync(c, iface, log); err != nil {
 			logrus.WithError(err).Errorln("cannot sync interface")
+			return
+		}
+		// sync creates missing link, possibly as userspace device
+		if done := wgquick.UserspaceDone(iface); done != nil {
+			waitUserspace(c, iface, done, log)
+		}
+	case "status":
+		printStatus(c, iface)
+	case "qr":
+		printQR(c, *qrPNG, *strict)
+	case "failover":
+		cl, err := wgctrl.New()
+		if err != nil {
+			logrus.WithError(err).Fatalln("cannot open wireguard control client")
+		}
+		defer cl.Close()
+		if err := wgquick.NewEndpointFailover(cl, c, iface, log).Run(context.Background()); err != nil {
+			logrus.WithError(err).Errorln("cannot monitor endpoints")
+		}
+	case "healthcheck":
+		// cancel on SIGINT/SIGTERM so Run installs the withdrawn routes back before exiting
+		ctx, cancel := signalContext()
+		defer cancel()
+		if err := wgquick.NewHealthChecker(c, iface, log).Run(ctx); err != nil && err != context.Canceled {
+			logrus.WithError(err).Errorln("cannot health check peers")
+		}
+	default:
+		printHelp()
+	}
+}
+
+// checkConfig prints all syntax and semantic problems of the config file, exiting with 1 if it's unusable
+func checkConfig(path string, format string) {
+	var c *wgquick.Config
+	var err error
+	if format == "" && wgquick.FormatFromPath(path) == wgquick.FormatINI || format == string(wgquick.FormatINI) {
+		var b []byte
+		if b, err = ioutil.ReadFile(path); err != nil {
+			logrus.WithError(err).Fatalln("cannot read config file")
+		}
+		if c, err = wgquick.ParseConfig(path, b, true); err == nil {
+			err = wgquick.MergeDropIns(c, path)
+		}
+	} else {
+		c, err = readConfig(path, format)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	v := c.Validate()
+	for _, p := range v.Errors {
+		fmt.Printf("%s: error: %s\n", path, p)
+	}
+	for _, p := range v.Warnings {
+		fmt.Printf("%s: warning: %s\n", path, p)
+	}
+	if v.Err() != nil {
+		os.Exit(1)
+	}
+}
+
+// readConfig reads the config file in the given format, or the one guessed from its extension if empty.
+// Drop-in peers are merged for every format, otherwise Sync would remove them from the device
+func readConfig(path string, format string) (*wgquick.Config, error) {
+	c, err := decodeConfig(path, format)
+	if err != nil {
+		return nil, err
+	}
+	if err := wgquick.MergeDropIns(c, path); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func decodeConfig(path string, format string) (*wgquick.Config, error) {
+	if format == "networkd" || format == "" && filepath.Ext(path) == ".netdev" {
+		c, warnings, err := wgquick.ReadNetworkdFiles(path)
+		for _, w := range warnings {
+			logrus.Warnln(w)
+		}
+		return c, err
+	}
+	if format == "networkmanager" || format == "" && filepath.Ext(path) == ".nmconnection" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		c, _, warnings, err := wgquick.FromNetworkManager(b)
+		for _, w := range warnings {
+			logrus.Warnln(w)
+		}
+		return c, err
+	}
+	if format == "" {
+		format = string(wgquick.FormatFromPath(path))
+	}
+	f, err := wgquick.ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return wgquick.DecodeConfig(f, path, b)
+}
+
+func convertConfig(path string, format string, to string, iface string, outDir string) {
+	c, err := readConfig(path, format)
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot read config file")
+	}
+	switch to {
+	case "networkd":
+		writeNetworkd(c, iface, outDir)
+		return
+	case "networkmanager":
+		b, warnings, err := c.ToNetworkManager(iface)
+		if err != nil {
+			logrus.WithError(err).Fatalln("cannot convert config")
+		}
+		for _, w := range warnings {
+			logrus.Warnln(w)
+		}
+		os.Stdout.Write(b)
+		return
+	}
+	f, err := wgquick.ParseFormat(to)
+	if err != nil {
+		logrus.WithError(err).Fatalln("invalid output format")
+	}
+	b, err := c.Encode(f)
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot encode config")
+	}
+	os.Stdout.Write(b)
+}
+
+// writeNetworkd writes the systemd-networkd units. The .netdev file contains the private key, systemd-networkd reads it
+// as the systemd-network group, so it gets that group and mode 0640. Without the group it stays readable by the owner only
+func writeNetworkd(c *wgquick.Config, iface string, outDir string) {
+	units, warnings, err := c.ToNetworkd(iface)
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot convert config")
+	}
+	for _, w := range warnings {
+		logrus.Warnln(w)
+	}
+	netdev := filepath.Join(outDir, iface+".netdev")
+	if err := ioutil.WriteFile(netdev, units.NetDev, 0600); err != nil {
+		logrus.WithError(err).Fatalln("cannot write netdev file")
+	}
+	if err := shareWithNetworkd(netdev); err != nil {
+		logrus.WithError(err).WithField("netdev", netdev).Warnln("systemd-networkd cannot read the netdev file, chown it to root:systemd-network and chmod 0640")
+	}
+	network := filepath.Join(outDir, iface+".network")
+	if err := ioutil.WriteFile(network, units.Network, 0644); err != nil {
+		logrus.WithError(err).Fatalln("cannot write network file")
+	}
+	logrus.WithField("netdev", netdev).WithField("network", network).Infoln("wrote systemd-networkd units")
+}
+
+func addPeer(server string, opts wgquick.ClientOptions, allowedIPs string, dns string) {
+	path, iface := server, strings.TrimSuffix(filepath.Base(server), filepath.Ext(server))
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		path = "/etc/wireguard/" + server + ".conf"
+	}
+	var err error
+	if allowedIPs != "" {
+		if opts.AllowedIPs, err = wgquick.ParseCIDRs(allowedIPs); err != nil {
+			logrus.WithError(err).Fatalln("cannot parse client allowed ips")
+		}
+	}
+	for _, s := range strings.Split(dns, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			logrus.WithField("dns", s).Fatalln("cannot parse client dns")
+		}
+		opts.DNS = append(opts.DNS, ip)
+	}
+
+	client, err := wgquick.AddClient(path, iface, opts, logrus.StandardLogger())
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot add peer")
+	}
+	fmt.Print(client.String())
+}
+
+// rotate rotates the keys of the interface, updating the remote configs found in remoteDir.
+// Sections for the other affected peers are written to outDir
+func rotate(path string, iface string, opts wgquick.RotateOptions, remoteDir string, outDir string) {
+	log := logrus.WithField("iface", iface)
+	r, err := wgquick.Rotate(path, iface, opts, logrus.StandardLogger())
+	if err != nil {
+		log.WithError(err).Fatalln("cannot rotate keys")
+	}
+
+	updated := make(map[wgtypes.Key]bool)
+	if remoteDir != "" {
+		remotes, err := filepath.Glob(filepath.Join(remoteDir, "*.conf"))
+		if err != nil {
+			log.WithError(err).Fatalln("cannot list remote configs")
+		}
+		for _, remotePath := range remotes {
+			remote, err := wgquick.ReadConfigFile(remotePath)
+			if err != nil {
+				log.WithError(err).WithField("path", remotePath).Errorln("cannot read remote config")
+				continue
+			}
+			ok, err := r.UpdateRemote(remote)
+			if err != nil {
+				log.WithError(err).WithField("path", remotePath).Errorln("cannot update remote config")
+				continue
+			}
+			if !ok {
+				continue
+			}
+			if err := wgquick.WriteConfigFile(remotePath, remote); err != nil {
+				log.WithError(err).WithField("path", remotePath).Fatalln("cannot write remote config")
+			}
+			updated[remote.PrivateKey.PublicKey()] = true
+			log.WithField("path", remotePath).Infoln("remote config updated")
+		}
+	}
+
+	for i, peer := range r.Config.Peers {
+		if _, ok := r.Remote[peer.PublicKey]; !ok || updated[peer.PublicKey] {
+			continue
+		}
+		section, err := r.RemoteSection(peer.PublicKey)
+		if err != nil {
+			log.WithError(err).Fatalln("cannot format remote section")
+		}
+		sectionPath := filepath.Join(outDir, fmt.Sprintf("%s-peer%d.conf", iface, i+1))
+		if err := ioutil.WriteFile(sectionPath, section, 0600); err != nil {
+			log.WithError(err).Fatalln("cannot write remote section")
+		}
+		log.WithField("peer", peer.PublicKey.String()).WithField("path", sectionPath).Warnln("remote peer has to be updated by hand")
+	}
+}
+
+// commitKeys switches the interface to the keys staged by rotate -window, optionally waiting until they're due
+func commitKeys(path string, iface string, wait bool) {
+	log := logrus.WithField("iface", iface)
+	if wait {
+		c, err := wgquick.ReadConfigFile(path)
+		if err != nil {
+			log.WithError(err).Fatalln("cannot read config file")
+		}
+		if c.PendingKeysAt.IsZero() {
+			log.Fatalln("config has no pending keys")
+		}
+		log.WithField("at", c.PendingKeysAt).Infoln("waiting for the pending keys")
+		time.Sleep(time.Until(c.PendingKeysAt))
+	}
+	if err := wgquick.CommitPendingKeys(path, iface, logrus.StandardLogger()); err != nil {
+		log.WithError(err).Fatalln("cannot commit pending keys")
+	}
+}
+
+// generate writes <out_dir>/<name>.conf for every generated config, keeping the existing node keys
+func generate(topology string, args []string, keyDir string) {
+	if len(args) < 1 || len(args) > 2 {
+		printHelp()
+	}
+	outDir := "."
+	if len(args) == 2 {
+		outDir = args[1]
+	}
+	if keyDir == "" {
+		keyDir = filepath.Join(outDir, "keys")
+	}
+
+	inv, err := wgquick.ReadInventory(args[0])
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot read inventory")
+	}
+	keys, err := inv.LoadOrCreateKeys(keyDir)
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot load node keys")
+	}
+	var configs map[string]*wgquick.Config
+	switch topology {
+	case "mesh":
+		configs, err = inv.FullMesh(keys)
+	case "hub-spoke":
+		configs, err = inv.HubAndSpoke(keys)
+	}
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot generate configs")
+	}
+	for name, c := range configs {
+		path := filepath.Join(outDir, name+".conf")
+		if err := wgquick.WriteConfigFile(path, c); err != nil {
+			logrus.WithError(err).Fatalln("cannot write config")
+		}
+		logrus.WithField("node", name).WithField("path", path).Infoln("config written")
+	}
+}
+
+// printStatus prints the peers like wg show does, with the config file each peer came from
+func printStatus(c *wgquick.Config, iface string) {
+	cl, err := wgctrl.New()
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot open wireguard control client")
+	}
+	defer cl.Close()
+	statuses, err := wgquick.Status(cl, c, iface)
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot read device")
+	}
+	if c.PendingKeysOverdue(time.Now()) {
+		fmt.Printf("pending keys: overdue since %s, run wg-quick commit-keys\n\n", c.PendingKeysAt.Format(time.RFC3339))
+		logrus.WithField("due", c.PendingKeysAt).Warnln("pending keys overdue, remote peers already switched to them")
+	} else if !c.PendingKeysAt.IsZero() {
+		fmt.Printf("pending keys: due %s\n\n", c.PendingKeysAt.Format(time.RFC3339))
+	}
+	for _, st := range statuses {
+		fmt.Printf("peer: %s\n", st.PublicKey)
+		switch {
+		case !st.Configured:
+			fmt.Printf("  source: (not in config, removed by next sync)\n")
+		case !st.Active:
+			fmt.Printf("  source: %s (not on device, added by next sync)\n", st.Source)
+		default:
+			fmt.Printf("  source: %s\n", st.Source)
+		}
+		if st.Endpoint != nil {
+			fmt.Printf("  endpoint: %s\n", st.Endpoint)
+		}
+		fmt.Printf("  allowed ips: %s\n", wgquick.FormatCIDRs(st.AllowedIPs))
+		if !st.LastHandshake.IsZero() {
+			fmt.Printf("  latest handshake: %s ago\n", time.Since(st.LastHandshake).Round(time.Second))
+		}
+		if st.Active {
+			fmt.Printf("  transfer: %d B received, %d B sent\n", st.ReceiveBytes, st.TransmitBytes)
+		}
+		fmt.Println()
+	}
+}
+
+func printQR(c *wgquick.Config, pngPath string, strict bool) {
+	_, warnings, err := c.MobileText()
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot encode config")
+	}
+	for _, w := range warnings {
+		logrus.Warnln(w)
+	}
+	if strict && len(warnings) > 0 {
+		logrus.Fatalln("config has settings mobile clients don't support")
+	}
+
+	if pngPath == "" {
+		if _, err := c.WriteQRTerminal(os.Stdout); err != nil {
+			logrus.WithError(err).Fatalln("cannot render qr code")
+		}
+		return
+	}
+	png, _, err := c.QRPNG(512)
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot render qr code")
+	}
+	if err := ioutil.WriteFile(pngPath, png, 0600); err != nil {
+		logrus.WithError(err).Fatalln("cannot write qr code")
+	}
+}
+
+func allowedIPs(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		printHelp()
+	}
+	allowed, err := wgquick.ParseCIDRs(args[0])
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot parse allowed ips")
+	}
+	var excluded []net.IPNet
+	if len(args) == 2 {
+		excluded, err = wgquick.ParseCIDRs(args[1])
+		if err != nil {
+			logrus.WithError(err).Fatalln("cannot parse excluded ips")
 		}
+	}
+	fmt.Println(wgquick.FormatCIDRs(wgquick.SubtractCIDRs(allowed, excluded)))
+}
+
+func manageAll(op string, args []string, depends string) {
+	dir := wgquick.DefaultConfigDir
+	switch len(args) {
+	case 0:
+	case 1:
+		dir = args[0]
 	default:
 		printHelp()
 	}
+
+	m := wgquick.NewManager(dir, logrus.StandardLogger())
+	deps, err := wgquick.ParseDependencies(depends)
+	if err != nil {
+		logrus.WithError(err).Fatalln("invalid -depends")
+	}
+	m.Dependencies = deps
+	switch op {
+	case "up-all":
+		err = m.UpAll()
+	case "down-all":
+		err = m.DownAll()
+	case "sync-all":
+		err = m.SyncAll()
+	}
+	if err != nil {
+		logrus.WithError(err).Errorf("cannot %s interfaces", strings.TrimSuffix(op, "-all"))
+	}
+	waitUserspaceAll(m)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// waitUserspaceAll keeps the process running while it hosts userspace wireguard-go devices created by the manager,
+// bringing them down on SIGINT/SIGTERM
+func waitUserspaceAll(m *wgquick.Manager) {
+	ifaces := wgquick.UserspaceInterfaces()
+	if len(ifaces) == 0 {
+		return
+	}
+	logrus.WithField("ifaces", ifaces).Infoln("running userspace wireguard-go devices, interrupt to bring them down")
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		for _, iface := range ifaces {
+			if ifaceDone := wgquick.UserspaceDone(iface); ifaceDone != nil {
+				<-ifaceDone
+			}
+		}
+		close(done)
+	}()
+	select {
+	case <-sig:
+		cfgs, err := m.Load()
+		if err != nil {
+			logrus.WithError(err).Errorln("cannot read some configs")
+		}
+		for _, iface := range ifaces {
+			log := logrus.WithField("iface", iface)
+			cfg, ok := cfgs[iface]
+			if !ok {
+				cfg = &wgquick.Config{}
+			}
+			if err := wgquick.Down(cfg, iface, log); err != nil {
+				log.WithError(err).Errorln("cannot down interface")
+			}
+		}
+	case <-done:
+		logrus.Infoln("userspace devices closed")
+	}
+}
+
+func serveMetrics(args []string) {
+	addr := ":9586"
+	switch len(args) {
+	case 0:
+	case 1:
+		addr = args[0]
+	default:
+		printHelp()
+	}
+
+	cl, err := wgctrl.New()
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot open wireguard control client")
+	}
+	defer cl.Close()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(wgquick.NewCollector(cl))
+	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	logrus.WithField("addr", addr).Infoln("serving metrics")
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		logrus.WithError(err).Fatalln("cannot serve metrics")
+	}
+}
+
+// shareWithNetworkd makes the file readable by the systemd-network group
+func shareWithNetworkd(path string) error {
+	group, err := user.LookupGroup("systemd-network")
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(group.Gid)
+	if err != nil {
+		return err
+	}
+	// group first, so the key is never readable by the group the file was created with
+	if err := os.Chown(path, -1, gid); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0640)
+}
+
+func printEvents(iface string, interval, staleAfter time.Duration) {
+	cl, err := wgctrl.New()
+	if err != nil {
+		logrus.WithError(err).Fatalln("cannot open wireguard control client")
+	}
+	defer cl.Close()
+
+	w := wgquick.NewWatcher(cl, iface, logrus.StandardLogger())
+	w.Interval = interval
+	w.StaleAfter = staleAfter
+	go func() {
+		enc := json.NewEncoder(os.Stdout)
+		for ev := range w.Events() {
+			if err := enc.Encode(ev); err != nil {
+				logrus.WithError(err).Fatalln("cannot write event")
+			}
+		}
+	}()
+	if err := w.Run(context.Background()); err != nil {
+		logrus.WithError(err).Fatalln("cannot watch interface")
+	}
+}
+
+// signalContext returns context cancelled on SIGINT/SIGTERM
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sig)
+	}()
+	return ctx, cancel
+}
+
+// waitUserspace keeps the process running while it hosts userspace wireguard-go device, bringing the interface down on SIGINT/SIGTERM
+func waitUserspace(c *wgquick.Config, iface string, done <-chan struct{}, log logrus.FieldLogger) {
+	log.Infoln("running userspace wireguard-go device, interrupt to bring it down")
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-sig:
+		if err := wgquick.Down(c, iface, log); err != nil {
+			logrus.WithError(err).Errorln("cannot down interface")
+		}
+	case <-done:
+		log.Infoln("userspace device closed")
+	}
 }
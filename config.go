@@ -5,7 +5,10 @@ import (
 	"encoding"
 	"encoding/base64"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"text/template"
@@ -45,9 +48,122 @@ type Config struct {
 	// Address label to set on the link
 	AddressLabel string
 
+	// Exclusive makes Sync delete all IPv4 addresses and routes (matching Table and RouteProtocol) on the link which aren't in the config,
+	// not only those recorded in the interface State
+	Exclusive bool
+
+	// Forwarding enables IPv4 forwarding of packets received on the interface and IPv6 forwarding system wide, needed by hubs routing between peers
+	Forwarding bool
+
+	// Userspace controls whether the embedded wireguard-go is used instead of the kernel module. Defaults to kernel with userspace fallback
+	Userspace UserspaceMode
+
+	// LockTimeout is how long Up/Down/Sync wait for the interface lock. Zero means DefaultLockTimeout
+	LockTimeout time.Duration
+
+	// PrivateKeyFile is the file PrivateKey was read from, see ReadKeyFile. MarshalText writes the file reference instead of the key.
+	// Relative paths are relative to the directory of the config file
+	PrivateKeyFile string
+
+	// PendingPrivateKey is the private key staged by Rotate with a Window. PrivateKey stays in use until PendingKeysAt
+	PendingPrivateKey *wgtypes.Key
+
+	// PendingKeysAt is when the pending keys replace the current ones. From then on Sync configures the pending keys,
+	// CommitPendingKeys writes them into the config
+	PendingKeysAt time.Time
+
+	// PeerSources holds the file each peer was read from by ReadConfigFile, by peer public key
+	PeerSources map[wgtypes.Key]string
+
+	// PeerOptions holds wg-quick-go specific peer settings by peer public key
+	PeerOptions map[wgtypes.Key]*PeerOptions
+
 	// SaveConfig — if set to ‘true’, the configuration is saved from the current state of the interface upon shutdown.
 	// Currently unsupported
 	SaveConfig bool
+
+	// dir is the directory of the parsed config file, relative key file paths are resolved against it
+	dir string
+}
+
+// PeerOptions are wg-quick-go specific peer settings, which don't fit into wgtypes.PeerConfig
+type PeerOptions struct {
+	// FallbackEndpoints are tried in order by EndpointFailover when the peer stops handshaking over its Endpoint
+	FallbackEndpoints []*net.UDPAddr
+
+	// HealthCheck probes the peer through the tunnel. Routes to peers failing it are withdrawn, see HealthChecker
+	HealthCheck *HealthCheck
+
+	// RouteMetric overrides Config.RouteMetric for routes to this peer AllowedIPs
+	RouteMetric int
+
+	// PresharedKeyFile is the file the peer PresharedKey was read from, see Config.PrivateKeyFile
+	PresharedKeyFile string
+
+	// PendingPublicKey is the peer public key after its pending rotation, see Config.PendingKeysAt
+	PendingPublicKey *wgtypes.Key
+
+	// PendingPresharedKey is the preshared key staged by Rotate, see Config.PendingKeysAt
+	PendingPresharedKey *wgtypes.Key
+
+	// ExcludedIPs are subtracted from the peer AllowedIPs when the config is applied, the config keeps both as written
+	ExcludedIPs []net.IPNet
+}
+
+func (opts *PeerOptions) empty() bool {
+	return len(opts.FallbackEndpoints) == 0 && opts.HealthCheck == nil && opts.RouteMetric == 0 && opts.PresharedKeyFile == "" &&
+		opts.PendingPublicKey == nil && opts.PendingPresharedKey == nil && len(opts.ExcludedIPs) == 0
+}
+
+func (opts *PeerOptions) healthCheck() *HealthCheck {
+	if opts.HealthCheck == nil {
+		opts.HealthCheck = &HealthCheck{}
+	}
+	return opts.HealthCheck
+}
+
+// Options returns peer options for the given peer, creating them if needed
+func (cfg *Config) Options(key wgtypes.Key) *PeerOptions {
+	if cfg.PeerOptions == nil {
+		cfg.PeerOptions = make(map[wgtypes.Key]*PeerOptions)
+	}
+	opts, ok := cfg.PeerOptions[key]
+	if !ok {
+		opts = &PeerOptions{}
+		cfg.PeerOptions[key] = opts
+	}
+	return opts
+}
+
+// allowedIPs returns AllowedIPs of the peer with its ExcludedIPs subtracted, the prefixes actually routed to it
+func (cfg *Config) allowedIPs(peer wgtypes.PeerConfig) []net.IPNet {
+	opts, ok := cfg.PeerOptions[peer.PublicKey]
+	if !ok || len(opts.ExcludedIPs) == 0 {
+		return peer.AllowedIPs
+	}
+	return SubtractCIDRs(peer.AllowedIPs, opts.ExcludedIPs)
+}
+
+// withExcludedIPs returns a copy of the config with ExcludedIPs subtracted from the AllowedIPs of each peer, the way it is applied.
+// The config itself is returned if no peer has ExcludedIPs
+func (cfg *Config) withExcludedIPs() *Config {
+	excluded := false
+	for _, peer := range cfg.Peers {
+		if opts, ok := cfg.PeerOptions[peer.PublicKey]; ok && len(opts.ExcludedIPs) > 0 {
+			excluded = true
+			break
+		}
+	}
+	if !excluded {
+		return cfg
+	}
+	expanded := *cfg
+	expanded.Peers = make([]wgtypes.PeerConfig, len(cfg.Peers))
+	for i, peer := range cfg.Peers {
+		peer.AllowedIPs = cfg.allowedIPs(peer)
+		expanded.Peers[i] = peer
+	}
+	return &expanded
 }
 
 var _ encoding.TextMarshaler = (*Config)(nil)
@@ -69,9 +185,14 @@ func toSeconds(duration time.Duration) int {
 	return int(duration / time.Second)
 }
 
+func toRFC3339(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
 var funcMap = template.FuncMap(map[string]interface{}{
 	"wgKey":     serializeKey,
 	"toSeconds": toSeconds,
+	"rfc3339":   toRFC3339,
 })
 
 var cfgTemplate = template.Must(
@@ -95,26 +216,97 @@ Address = {{ . }}
 {{- range .DNS }}
 DNS = {{ . }}
 {{- end }}
+{{- if .PrivateKeyFile }}
+PrivateKeyFile = {{ .PrivateKeyFile }}
+{{- else }}
 PrivateKey = {{ .PrivateKey | wgKey }}
+{{- end }}
+{{- if .PendingPrivateKey }}{{ "\n" }}PendingPrivateKey = {{ .PendingPrivateKey | wgKey }}{{ end }}
+{{- if not .PendingKeysAt.IsZero }}{{ "\n" }}PendingKeysAt = {{ .PendingKeysAt | rfc3339 }}{{ end }}
 {{- if .ListenPort }}{{ "\n" }}ListenPort = {{ .ListenPort }}{{ end }}
+{{- if .FirewallMark }}{{ "\n" }}FwMark = {{ .FirewallMark }}{{ end }}
 {{- if .MTU }}{{ "\n" }}MTU = {{ .MTU }}{{ end }}
 {{- if .Table }}{{ "\n" }}Table = {{ .Table }}{{ end }}
 {{- if .PreUp }}{{ "\n" }}PreUp = {{ .PreUp }}{{ end }}
 {{- if .PostUp }}{{ "\n" }}PostUp = {{ .PostUp }}{{ end }}
 {{- if .PreDown }}{{ "\n" }}PreDown = {{ .PreDown }}{{ end }}
 {{- if .PostDown }}{{ "\n" }}PostDown = {{ .PostDown }}{{ end }}
+{{- if .Forwarding }}{{ "\n" }}Forwarding = {{ .Forwarding }}{{ end }}
 {{- if .SaveConfig }}{{ "\n" }}SaveConfig = {{ .SaveConfig }}{{ end }}
 {{- range .Peers }}
 {{- "\n" }}
 [Peer]
 PublicKey = {{ .PublicKey | wgKey }}
 AllowedIPs = {{ range $i, $el := .AllowedIPs }}{{if $i}}, {{ end }}{{ $el }}{{ end }}
-{{- if .PresharedKey }}{{ "\n" }}PresharedKey = {{ .PresharedKey }}{{ end }}
+{{- with index $.PeerOptions .PublicKey }}{{ if .ExcludedIPs }}{{ "\n" }}ExcludedIPs = {{ range $i, $el := .ExcludedIPs }}{{if $i}}, {{ end }}{{ $el }}{{ end }}{{ end }}{{ end }}
+{{- $pskFile := "" }}{{ with index $.PeerOptions .PublicKey }}{{ $pskFile = .PresharedKeyFile }}{{ end }}
+{{- if $pskFile }}{{ "\n" }}PresharedKeyFile = {{ $pskFile }}
+{{- else if .PresharedKey }}{{ "\n" }}PresharedKey = {{ .PresharedKey }}{{ end }}
 {{- if .PersistentKeepaliveInterval }}{{ "\n" }}PersistentKeepalive = {{ .PersistentKeepaliveInterval | toSeconds }}{{ end }}
 {{- if .Endpoint }}{{ "\n" }}Endpoint = {{ .Endpoint }}{{ end }}
+{{- with index $.PeerOptions .PublicKey }}
+{{- range .FallbackEndpoints }}{{ "\n" }}FallbackEndpoint = {{ . }}{{ end }}
+{{- with .HealthCheck }}
+{{- if .Protocol }}{{ "\n" }}HealthCheck = {{ .Protocol }} {{ .Target }}{{ end }}
+{{- if .Interval }}{{ "\n" }}HealthCheckInterval = {{ .Interval | toSeconds }}{{ end }}
+{{- if .Threshold }}{{ "\n" }}HealthCheckThreshold = {{ .Threshold }}{{ end }}
+{{- end }}
+{{- if .RouteMetric }}{{ "\n" }}RouteMetric = {{ .RouteMetric }}{{ end }}
+{{- if .PendingPublicKey }}{{ "\n" }}PendingPublicKey = {{ .PendingPublicKey | wgKey }}{{ end }}
+{{- if .PendingPresharedKey }}{{ "\n" }}PendingPresharedKey = {{ .PendingPresharedKey | wgKey }}{{ end }}
+{{- end }}
 {{- end }}
 `
 
+// ReadConfigFile reads and parses config file, in JSON or YAML for .json, .yaml and .yml files, otherwise wg-quick format.
+// Peers from the drop-in files in DropInDir are merged in, PeerSources records the file of every peer
+func ReadConfigFile(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := DecodeConfig(FormatFromPath(path), path, b)
+	if err != nil {
+		return nil, err
+	}
+	if err := MergeDropIns(cfg, path); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// WriteConfigFile atomically writes the config to the file, readable only by the owner since it contains private keys.
+// The format is chosen by file extension like in ReadConfigFile. Keys referenced by PrivateKeyFile and PresharedKeyFile
+// are written to their files, peers read from drop-ins of path are written back to their drop-in files
+func WriteConfigFile(path string, cfg *Config) error {
+	if err := writeKeyFiles(cfg, filepath.Dir(path)); err != nil {
+		return err
+	}
+	main, dropIns, err := cfg.splitDropIns(path)
+	if err != nil {
+		return err
+	}
+	for file, text := range dropIns {
+		if err := writeFile(file, text); err != nil {
+			return err
+		}
+	}
+	b, err := main.Encode(FormatFromPath(path))
+	if err != nil {
+		return err
+	}
+	return writeFile(path, b)
+}
+
+// writeFile atomically writes the file, readable only by the owner
+func writeFile(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 // ParseKey parses the base64 encoded wireguard private key
 func ParseKey(key string) (wgtypes.Key, error) {
 	var pkey wgtypes.Key
@@ -130,19 +322,62 @@ type parseState int
 
 const (
 	unknown parseState = iota
-	inter              = iota
-	peer               = iota
+	inter
+	peer
 )
 
+func (s parseState) String() string {
+	switch s {
+	case inter:
+		return "Interface"
+	case peer:
+		return "Peer"
+	default:
+		return ""
+	}
+}
+
+// UnmarshalText parses wg-quick config, stopping at the first error. Returned error is *ParseError
 func (cfg *Config) UnmarshalText(text []byte) error {
-	*cfg = Config{} // Zero out the config
+	if errs := cfg.parse("", text, false); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// ParseConfig parses wg-quick config text, file is used in error messages and to resolve relative key file paths.
+// If collectAll is set, parsing continues after errors and all of them are returned as ParseErrors, otherwise the first *ParseError is returned
+func ParseConfig(file string, text []byte, collectAll bool) (*Config, error) {
+	cfg := &Config{}
+	errs := cfg.parse(file, text, collectAll)
+	switch {
+	case len(errs) == 0:
+		return cfg, nil
+	case collectAll:
+		return nil, errs
+	default:
+		return nil, errs[0]
+	}
+}
+
+func (cfg *Config) parse(file string, text []byte, collectAll bool) ParseErrors {
+	*cfg = Config{dir: configDir(file)} // Zero out the config
 	state := unknown
 	var peerCfg *wgtypes.PeerConfig
+	// options per peer index, keyed by the public key once the whole file is read
+	var peerOpts []*PeerOptions
+	var errs ParseErrors
 	for no, line := range strings.Split(string(text), "\n") {
 		ln := strings.TrimSpace(line)
 		if len(ln) == 0 || ln[0] == '#' {
 			continue
 		}
+		perr := &ParseError{
+			File:    file,
+			Line:    no + 1,
+			Column:  strings.Index(line, ln) + 1,
+			Section: state.String(),
+		}
 		switch ln {
 		case "[Interface]":
 			state = inter
@@ -150,30 +385,47 @@ func (cfg *Config) UnmarshalText(text []byte) error {
 			state = peer
 			cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{})
 			peerCfg = &cfg.Peers[len(cfg.Peers)-1]
+			peerOpts = append(peerOpts, &PeerOptions{})
 		default:
-			parts := strings.Split(ln, "=")
-			if len(parts) < 2 {
-				return fmt.Errorf("cannot parse line %d, missing =", no)
-			}
-			lhs := strings.TrimSpace(parts[0])
-			rhs := strings.TrimSpace(strings.Join(parts[1:], "="))
-
-			switch state {
-			case inter:
-				if err := parseInterfaceLine(cfg, lhs, rhs); err != nil {
-					return fmt.Errorf("[line %d]: %v", no+1, err)
+			eq := strings.Index(line, "=")
+			if eq < 0 {
+				perr.Err = fmt.Errorf("cannot parse line, missing =")
+				if ln[0] == '[' {
+					perr.Err = fmt.Errorf("unknown section %s", ln)
 				}
-			case peer:
-				if err := parsePeerLine(peerCfg, lhs, rhs); err != nil {
-					return fmt.Errorf("[line %d]: %v", no+1, err)
-				}
-			default:
-				return fmt.Errorf("[line %d] cannot parse, unknown state", no+1)
+			} else {
+				perr.Key = strings.TrimSpace(line[:eq])
+				perr.Value = strings.TrimSpace(line[eq+1:])
+				perr.Column = eq + 2 + strings.Index(line[eq+1:], perr.Value)
+				perr.Err = parseLine(cfg, state, peerCfg, peerOpts, perr.Key, perr.Value)
+			}
+		}
+		if perr.Err != nil {
+			errs = append(errs, perr)
+			if !collectAll {
+				return errs
 			}
 		}
 	}
-	return nil
+	for idx, opts := range peerOpts {
+		if !opts.empty() {
+			*cfg.Options(cfg.Peers[idx].PublicKey) = *opts
+		}
+	}
+	return errs
+}
+
+func parseLine(cfg *Config, state parseState, peerCfg *wgtypes.PeerConfig, peerOpts []*PeerOptions, lhs, rhs string) error {
+	switch state {
+	case inter:
+		return parseInterfaceLine(cfg, lhs, rhs)
+	case peer:
+		return parsePeerLine(cfg, peerCfg, peerOpts[len(peerOpts)-1], lhs, rhs)
+	default:
+		return fmt.Errorf("directive outside of [Interface] or [Peer] section")
+	}
 }
+
 func parseInterfaceLine(cfg *Config, lhs string, rhs string) error {
 	switch lhs {
 	case "Address":
@@ -211,6 +463,16 @@ func parseInterfaceLine(cfg *Config, lhs string, rhs string) error {
 		}
 		port := int(portI64)
 		cfg.ListenPort = &port
+	case "FwMark":
+		if rhs == "off" {
+			rhs = "0"
+		}
+		mark, err := strconv.ParseUint(rhs, 0, 32)
+		if err != nil {
+			return err
+		}
+		fwmark := int(mark)
+		cfg.FirewallMark = &fwmark
 	case "PreUp":
 		cfg.PreUp = rhs
 	case "PostUp":
@@ -219,6 +481,12 @@ func parseInterfaceLine(cfg *Config, lhs string, rhs string) error {
 		cfg.PreDown = rhs
 	case "PostDown":
 		cfg.PostDown = rhs
+	case "Forwarding":
+		forwarding, err := strconv.ParseBool(rhs)
+		if err != nil {
+			return err
+		}
+		cfg.Forwarding = forwarding
 	case "SaveConfig":
 		save, err := strconv.ParseBool(rhs)
 		if err != nil {
@@ -230,14 +498,39 @@ func parseInterfaceLine(cfg *Config, lhs string, rhs string) error {
 		if err != nil {
 			return fmt.Errorf("cannot decode key %v", err)
 		}
+		if cfg.PrivateKeyFile != "" {
+			return fmt.Errorf("private key already read from %s", cfg.PrivateKeyFile)
+		}
+		cfg.PrivateKey = &key
+	case "PrivateKeyFile":
+		if cfg.PrivateKey != nil {
+			return fmt.Errorf("private key already defined")
+		}
+		key, err := ReadKeyFile(resolveKeyFile(cfg.dir, rhs))
+		if err != nil {
+			return err
+		}
 		cfg.PrivateKey = &key
+		cfg.PrivateKeyFile = rhs
+	case "PendingPrivateKey":
+		key, err := ParseKey(rhs)
+		if err != nil {
+			return fmt.Errorf("cannot decode key %v", err)
+		}
+		cfg.PendingPrivateKey = &key
+	case "PendingKeysAt":
+		until, err := time.Parse(time.RFC3339, rhs)
+		if err != nil {
+			return err
+		}
+		cfg.PendingKeysAt = until
 	default:
 		return fmt.Errorf("unknown directive %s", lhs)
 	}
 	return nil
 }
 
-func parsePeerLine(peerCfg *wgtypes.PeerConfig, lhs string, rhs string) error {
+func parsePeerLine(cfg *Config, peerCfg *wgtypes.PeerConfig, opts *PeerOptions, lhs string, rhs string) error {
 	switch lhs {
 	case "PublicKey":
 		key, err := ParseKey(rhs)
@@ -254,20 +547,80 @@ func parsePeerLine(peerCfg *wgtypes.PeerConfig, lhs string, rhs string) error {
 			return fmt.Errorf("preshared key already defined %v", err)
 		}
 		peerCfg.PresharedKey = &key
+	case "PresharedKeyFile":
+		if peerCfg.PresharedKey != nil {
+			return fmt.Errorf("preshared key already defined")
+		}
+		key, err := ReadKeyFile(resolveKeyFile(cfg.dir, rhs))
+		if err != nil {
+			return err
+		}
+		peerCfg.PresharedKey = &key
+		opts.PresharedKeyFile = rhs
 	case "AllowedIPs":
-		for _, addr := range strings.Split(rhs, ",") {
-			ip, cidr, err := net.ParseCIDR(strings.TrimSpace(addr))
-			if err != nil {
-				return fmt.Errorf("cannot parse %s: %v", addr, err)
-			}
-			peerCfg.AllowedIPs = append(peerCfg.AllowedIPs, net.IPNet{IP: ip, Mask: cidr.Mask})
+		nets, err := ParseCIDRs(rhs)
+		if err != nil {
+			return err
 		}
+		peerCfg.AllowedIPs = append(peerCfg.AllowedIPs, nets...)
+	case "ExcludedIPs":
+		nets, err := ParseCIDRs(rhs)
+		if err != nil {
+			return err
+		}
+		opts.ExcludedIPs = append(opts.ExcludedIPs, nets...)
 	case "Endpoint":
 		addr, err := net.ResolveUDPAddr("", rhs)
 		if err != nil {
 			return err
 		}
 		peerCfg.Endpoint = addr
+	case "FallbackEndpoint":
+		for _, endpoint := range strings.Split(rhs, ",") {
+			addr, err := net.ResolveUDPAddr("", strings.TrimSpace(endpoint))
+			if err != nil {
+				return err
+			}
+			opts.FallbackEndpoints = append(opts.FallbackEndpoints, addr)
+		}
+	case "HealthCheck":
+		fields := strings.Fields(rhs)
+		if len(fields) != 2 || (fields[0] != "icmp" && fields[0] != "tcp") {
+			return fmt.Errorf("expected icmp <ip> or tcp <ip:port>, got %s", rhs)
+		}
+		hc := opts.healthCheck()
+		hc.Protocol = fields[0]
+		hc.Target = fields[1]
+	case "HealthCheckInterval":
+		t, err := strconv.ParseInt(rhs, 10, 64)
+		if err != nil {
+			return err
+		}
+		opts.healthCheck().Interval = time.Duration(t * int64(time.Second))
+	case "HealthCheckThreshold":
+		threshold, err := strconv.ParseInt(rhs, 10, 64)
+		if err != nil {
+			return err
+		}
+		opts.healthCheck().Threshold = int(threshold)
+	case "RouteMetric":
+		metric, err := strconv.ParseInt(rhs, 10, 32)
+		if err != nil {
+			return err
+		}
+		opts.RouteMetric = int(metric)
+	case "PendingPublicKey":
+		key, err := ParseKey(rhs)
+		if err != nil {
+			return fmt.Errorf("cannot decode key %v", err)
+		}
+		opts.PendingPublicKey = &key
+	case "PendingPresharedKey":
+		key, err := ParseKey(rhs)
+		if err != nil {
+			return fmt.Errorf("cannot decode key %v", err)
+		}
+		opts.PendingPresharedKey = &key
 	case "PersistentKeepalive":
 		t, err := strconv.ParseInt(rhs, 10, 64)
 		if err != nil {
@@ -1,9 +1,11 @@
 package wgquick
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var testConfigs = map[string]string{
@@ -49,6 +51,55 @@ PreDown = ip rule delete ipproto tcp dport 22 table 1234
 PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
 AllowedIPs = 0.0.0.0/0
 PersistentKeepalive = 25
+`,
+	"fallback-endpoints": `[Interface]
+Address = 10.200.100.8/24
+PrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+
+[Peer]
+PublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+AllowedIPs = 10.200.100.0/24
+Endpoint = 123.12.12.1:51820
+FallbackEndpoint = 124.12.12.1:51820
+FallbackEndpoint = 125.12.12.1:51820
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.192.122.3/32
+`,
+	"health-check": `[Interface]
+Address = 10.192.122.1/24
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+ListenPort = 51820
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.192.122.3/32, 192.168.0.0/16
+HealthCheck = tcp 10.192.122.3:22
+HealthCheckInterval = 5
+HealthCheckThreshold = 2
+
+[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 10.192.122.4/32, 172.16.0.0/12
+HealthCheck = icmp 10.192.122.4
+`,
+	"hub": `[Interface]
+Address = 10.192.122.2/32
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Forwarding = true
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.192.122.0/24
+Endpoint = 192.95.5.67:1234
+RouteMetric = 100
+
+[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 192.168.0.0/16
+Endpoint = 192.95.5.68:1234
+RouteMetric = 200
 `,
 }
 
@@ -65,3 +116,62 @@ func TestExampleConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestFwMark(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte("[Interface]\nPrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=\nFwMark = 0xca6c\n")))
+	require.NotNil(t, c.FirewallMark)
+	assert.Equal(t, 0xca6c, *c.FirewallMark)
+	require.NoError(t, c.UnmarshalText([]byte("[Interface]\nPrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=\nFwMark = off\n")))
+	assert.Equal(t, 0, *c.FirewallMark)
+
+	text := strings.Replace(testConfigs["sample-3"], "ListenPort = 51820\n", "ListenPort = 51820\nFwMark = 51820\n", 1)
+	require.NoError(t, c.UnmarshalText([]byte(text)))
+	assert.Equal(t, text, c.String())
+	for _, format := range []Format{FormatJSON, FormatYAML} {
+		b, err := c.Encode(format)
+		require.NoError(t, err)
+		decoded, err := DecodeConfig(format, "", b)
+		require.NoError(t, err)
+		assert.Equal(t, 51820, *decoded.FirewallMark, format)
+	}
+	units, _, err := c.ToNetworkd("wg0")
+	require.NoError(t, err)
+	assert.Contains(t, string(units.NetDev), "FirewallMark=51820\n")
+}
+
+func TestParseErrors(t *testing.T) {
+	text := `Address = 10.0.0.1/24
+[Interface]
+PrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+MTU = big
+
+[Peer]
+  AllowedIPs
+PublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+AllowedIPs =  10.0.0.0/33
+`
+	_, err := ParseConfig("wg0.conf", []byte(text), false)
+	if assert.IsType(t, &ParseError{}, err) {
+		perr := err.(*ParseError)
+		assert.Equal(t, 1, perr.Line)
+		assert.Equal(t, "", perr.Section)
+		assert.Equal(t, "Address", perr.Key)
+	}
+
+	_, err = ParseConfig("wg0.conf", []byte(text), true)
+	require.IsType(t, ParseErrors{}, err)
+	errs := err.(ParseErrors)
+	require.Len(t, errs, 4)
+	assert.Equal(t, ParseError{File: "wg0.conf", Line: 4, Column: 7, Section: "Interface", Key: "MTU", Value: "big", Err: errs[1].Err}, *errs[1])
+	assert.Equal(t, 7, errs[2].Line)
+	assert.Equal(t, 3, errs[2].Column)
+	assert.Equal(t, "", errs[2].Key)
+	assert.Equal(t, "wg0.conf:9:15: [Peer] AllowedIPs: cannot parse 10.0.0.0/33: invalid CIDR address: 10.0.0.0/33", errs[3].Error())
+
+	c := &Config{}
+	err = c.UnmarshalText([]byte(text))
+	if assert.IsType(t, &ParseError{}, err) {
+		assert.Equal(t, "", err.(*ParseError).File)
+	}
+}
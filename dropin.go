@@ -0,0 +1,92 @@
+package wgquick
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DropInDir returns the drop-in directory of the config file, e.g. /etc/wireguard/wg0.conf.d for /etc/wireguard/wg0.conf.
+// Every *.conf file in it holds [Peer] sections in wg-quick format, merged into the config in lexical file order
+func DropInDir(path string) string {
+	return path + ".d"
+}
+
+// MergeDropIns merges the drop-in peers of DropInDir(path) into cfg read from path, recording the source file of every peer
+// in PeerSources. ReadConfigFile does it already, use it for configs read otherwise. Errors name the conflicting files
+func MergeDropIns(cfg *Config, path string) error {
+	files, err := filepath.Glob(filepath.Join(DropInDir(path), "*.conf"))
+	if err != nil {
+		return err
+	}
+	cfg.PeerSources = make(map[wgtypes.Key]string, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		cfg.PeerSources[peer.PublicKey] = path
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		for no, line := range strings.Split(string(b), "\n") {
+			if strings.TrimSpace(line) == "[Interface]" {
+				return fmt.Errorf("%s:%d: [Interface] section is only allowed in %s", file, no+1, path)
+			}
+		}
+		dropIn, err := ParseConfig(file, b, false)
+		if err != nil {
+			return err
+		}
+		for _, peer := range dropIn.Peers {
+			if source, ok := cfg.PeerSources[peer.PublicKey]; ok {
+				return fmt.Errorf("%s: peer %s already defined in %s", file, peer.PublicKey, source)
+			}
+			cfg.Peers = append(cfg.Peers, peer)
+			cfg.PeerSources[peer.PublicKey] = file
+			if opts, ok := dropIn.PeerOptions[peer.PublicKey]; ok {
+				*cfg.Options(peer.PublicKey) = *opts
+			}
+		}
+	}
+	return nil
+}
+
+// splitDropIns returns the config without the peers read from drop-ins of path, and the drop-in file texts.
+// Drop-ins of PeerSources left without peers get empty text
+func (cfg *Config) splitDropIns(path string) (*Config, map[string][]byte, error) {
+	main := *cfg
+	main.Peers = nil
+	dropIns := make(map[string]*Config)
+	for _, peer := range cfg.Peers {
+		source := cfg.PeerSources[peer.PublicKey]
+		if filepath.Dir(source) != DropInDir(path) {
+			main.Peers = append(main.Peers, peer)
+			continue
+		}
+		if _, ok := dropIns[source]; !ok {
+			dropIns[source] = &Config{PeerOptions: cfg.PeerOptions}
+			dropIns[source].PrivateKey = &wgtypes.Key{}
+		}
+		dropIns[source].Peers = append(dropIns[source].Peers, peer)
+	}
+
+	texts := make(map[string][]byte, len(dropIns))
+	// drop-ins whose peers were all removed are truncated, otherwise the peers come back on the next read
+	for _, source := range cfg.PeerSources {
+		if filepath.Dir(source) == DropInDir(path) {
+			texts[source] = nil
+		}
+	}
+	for file, dropIn := range dropIns {
+		text, err := dropIn.MarshalText()
+		if err != nil {
+			return nil, nil, err
+		}
+		texts[file] = text[bytes.Index(text, []byte("[Peer]\n")):]
+	}
+	return &main, texts, nil
+}
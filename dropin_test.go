@@ -0,0 +1,107 @@
+package wgquick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeDropIns(t *testing.T, files map[string]string) (dir string, path string) {
+	dir, err := ioutil.TempDir("", "wg-quick")
+	require.NoError(t, err)
+	path = filepath.Join(dir, "wg0.conf")
+	require.NoError(t, os.Mkdir(DropInDir(path), 0700))
+	for name, text := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0600))
+	}
+	return dir, path
+}
+
+func TestReadDropIns(t *testing.T) {
+	teamA := `# team a
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.192.122.3/32
+RouteMetric = 10
+`
+	teamB := `[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 10.192.124.0/24
+`
+	dir, path := writeDropIns(t, map[string]string{
+		"wg0.conf":              testConfigs["simple"],
+		"wg0.conf.d/20-b.conf":  teamB,
+		"wg0.conf.d/10-a.conf":  teamA,
+		"wg0.conf.d/README.txt": "ignored",
+	})
+	defer os.RemoveAll(dir)
+
+	cfg, err := ReadConfigFile(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Peers, 3)
+	assert.Equal(t, "GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=", cfg.Peers[0].PublicKey.String())
+	assert.Equal(t, "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", cfg.Peers[1].PublicKey.String(), "lexical order")
+	assert.Equal(t, "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=", cfg.Peers[2].PublicKey.String())
+	assert.Equal(t, path, cfg.PeerSources[cfg.Peers[0].PublicKey])
+	assert.Equal(t, filepath.Join(dir, "wg0.conf.d/10-a.conf"), cfg.PeerSources[cfg.Peers[1].PublicKey])
+	assert.Equal(t, 10, cfg.PeerOptions[cfg.Peers[1].PublicKey].RouteMetric)
+
+	// peers are written back to their files
+	psk := *cfg.Peers[0].PresharedKey
+	cfg.Peers[1].PresharedKey = &psk
+	require.NoError(t, WriteConfigFile(path, cfg))
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, testConfigs["simple"], string(b))
+	b, err = ioutil.ReadFile(filepath.Join(dir, "wg0.conf.d/10-a.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, `[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.192.122.3/32
+PresharedKey = `+psk.String()+`
+RouteMetric = 10
+`, string(b))
+	reread, err := ReadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, cfg.String(), reread.String())
+
+	// removing the last peer of a drop-in empties it
+	reread.Peers = reread.Peers[:2]
+	require.NoError(t, WriteConfigFile(path, reread))
+	b, err = ioutil.ReadFile(filepath.Join(dir, "wg0.conf.d/20-b.conf"))
+	require.NoError(t, err)
+	assert.Empty(t, b)
+	reread, err = ReadConfigFile(path)
+	require.NoError(t, err)
+	assert.Len(t, reread.Peers, 2)
+
+	// writing elsewhere merges the drop-ins
+	require.NoError(t, WriteConfigFile(filepath.Join(dir, "merged.conf"), cfg))
+	merged, err := ReadConfigFile(filepath.Join(dir, "merged.conf"))
+	require.NoError(t, err)
+	assert.Len(t, merged.Peers, 3)
+}
+
+func TestReadDropInsConflicts(t *testing.T) {
+	dir, path := writeDropIns(t, map[string]string{
+		"wg0.conf":             testConfigs["simple"],
+		"wg0.conf.d/team.conf": "[Peer]\nPublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=\nAllowedIPs = 10.0.0.0/8\n",
+	})
+	defer os.RemoveAll(dir)
+	team := filepath.Join(DropInDir(path), "team.conf")
+	_, err := ReadConfigFile(path)
+	assert.EqualError(t, err, team+": peer GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU= already defined in "+path)
+
+	require.NoError(t, ioutil.WriteFile(team, []byte("[Peer]\nPublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\n\n[Interface]\nListenPort = 1\n"), 0600))
+	_, err = ReadConfigFile(path)
+	assert.EqualError(t, err, team+":4: [Interface] section is only allowed in "+path)
+
+	require.NoError(t, ioutil.WriteFile(team, []byte("[Peer]\nPublicKey = not a key\n"), 0600))
+	_, err = ReadConfigFile(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), team+":2:")
+}
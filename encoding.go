@@ -0,0 +1,322 @@
+package wgquick
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v2"
+)
+
+// Format is config serialization format
+type Format string
+
+const (
+	// FormatINI is the wg-quick config format
+	FormatINI Format = "ini"
+	// FormatJSON is JSON with the schema of configDoc
+	FormatJSON Format = "json"
+	// FormatYAML is YAML with the same schema as FormatJSON
+	FormatYAML Format = "yaml"
+)
+
+// ParseFormat parses format name, yml is accepted as yaml
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "ini", "conf":
+		return FormatINI, nil
+	case "json":
+		return FormatJSON, nil
+	case "yaml", "yml":
+		return FormatYAML, nil
+	default:
+		return "", fmt.Errorf("unknown format %s", s)
+	}
+}
+
+// FormatFromPath guesses the format by file extension, defaulting to FormatINI
+func FormatFromPath(path string) Format {
+	if f, err := ParseFormat(strings.TrimPrefix(filepath.Ext(path), ".")); err == nil {
+		return f
+	}
+	return FormatINI
+}
+
+// Encode serializes the config in the given format
+func (cfg *Config) Encode(format Format) ([]byte, error) {
+	switch format {
+	case FormatINI:
+		return cfg.MarshalText()
+	case FormatJSON:
+		b, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	case FormatYAML:
+		return yaml.Marshal(cfg)
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+}
+
+// DecodeConfig parses the config in the given format, file is only used in error messages
+func DecodeConfig(format Format, file string, text []byte) (*Config, error) {
+	cfg := &Config{dir: configDir(file)}
+	var err error
+	switch format {
+	case FormatINI:
+		return ParseConfig(file, text, false)
+	case FormatJSON:
+		err = json.Unmarshal(text, cfg)
+	case FormatYAML:
+		err = yaml.UnmarshalStrict(text, cfg)
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+	if err != nil {
+		if file != "" {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// configDoc is the JSON/YAML schema. Keys are base64, addresses CIDR strings, endpoints host:port and durations seconds,
+// the same representation as in wg-quick config, so the conversion between the formats is lossless
+type configDoc struct {
+	Interface interfaceDoc `json:"interface" yaml:"interface"`
+	Peers     []peerDoc    `json:"peers,omitempty" yaml:"peers,omitempty"`
+}
+
+type interfaceDoc struct {
+	PrivateKey        string   `json:"privateKey,omitempty" yaml:"privateKey,omitempty"`
+	PrivateKeyFile    string   `json:"privateKeyFile,omitempty" yaml:"privateKeyFile,omitempty"`
+	PendingPrivateKey string   `json:"pendingPrivateKey,omitempty" yaml:"pendingPrivateKey,omitempty"`
+	PendingKeysAt     string   `json:"pendingKeysAt,omitempty" yaml:"pendingKeysAt,omitempty"`
+	ListenPort        *int     `json:"listenPort,omitempty" yaml:"listenPort,omitempty"`
+	FwMark            *int     `json:"fwMark,omitempty" yaml:"fwMark,omitempty"`
+	Address           []string `json:"address,omitempty" yaml:"address,omitempty"`
+	DNS               []string `json:"dns,omitempty" yaml:"dns,omitempty"`
+	MTU               int      `json:"mtu,omitempty" yaml:"mtu,omitempty"`
+	Table             int      `json:"table,omitempty" yaml:"table,omitempty"`
+	PreUp             string   `json:"preUp,omitempty" yaml:"preUp,omitempty"`
+	PostUp            string   `json:"postUp,omitempty" yaml:"postUp,omitempty"`
+	PreDown           string   `json:"preDown,omitempty" yaml:"preDown,omitempty"`
+	PostDown          string   `json:"postDown,omitempty" yaml:"postDown,omitempty"`
+	Forwarding        bool     `json:"forwarding,omitempty" yaml:"forwarding,omitempty"`
+	SaveConfig        bool     `json:"saveConfig,omitempty" yaml:"saveConfig,omitempty"`
+}
+
+type peerDoc struct {
+	PublicKey           string          `json:"publicKey" yaml:"publicKey"`
+	PresharedKey        string          `json:"presharedKey,omitempty" yaml:"presharedKey,omitempty"`
+	PresharedKeyFile    string          `json:"presharedKeyFile,omitempty" yaml:"presharedKeyFile,omitempty"`
+	AllowedIPs          []string        `json:"allowedIPs,omitempty" yaml:"allowedIPs,omitempty"`
+	ExcludedIPs         []string        `json:"excludedIPs,omitempty" yaml:"excludedIPs,omitempty"`
+	Endpoint            string          `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	PersistentKeepalive int             `json:"persistentKeepalive,omitempty" yaml:"persistentKeepalive,omitempty"`
+	FallbackEndpoints   []string        `json:"fallbackEndpoints,omitempty" yaml:"fallbackEndpoints,omitempty"`
+	HealthCheck         *healthCheckDoc `json:"healthCheck,omitempty" yaml:"healthCheck,omitempty"`
+	RouteMetric         int             `json:"routeMetric,omitempty" yaml:"routeMetric,omitempty"`
+	PendingPublicKey    string          `json:"pendingPublicKey,omitempty" yaml:"pendingPublicKey,omitempty"`
+	PendingPresharedKey string          `json:"pendingPresharedKey,omitempty" yaml:"pendingPresharedKey,omitempty"`
+}
+
+type healthCheckDoc struct {
+	Protocol  string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Target    string `json:"target,omitempty" yaml:"target,omitempty"`
+	Interval  int    `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Threshold int    `json:"threshold,omitempty" yaml:"threshold,omitempty"`
+}
+
+func (cfg *Config) toDoc() *configDoc {
+	doc := &configDoc{Interface: interfaceDoc{
+		ListenPort: cfg.ListenPort,
+		FwMark:     cfg.FirewallMark,
+		MTU:        cfg.MTU,
+		Table:      cfg.Table,
+		PreUp:      cfg.PreUp,
+		PostUp:     cfg.PostUp,
+		PreDown:    cfg.PreDown,
+		PostDown:   cfg.PostDown,
+		Forwarding: cfg.Forwarding,
+		SaveConfig: cfg.SaveConfig,
+	}}
+	switch {
+	case cfg.PrivateKeyFile != "":
+		doc.Interface.PrivateKeyFile = cfg.PrivateKeyFile
+	case cfg.PrivateKey != nil:
+		doc.Interface.PrivateKey = cfg.PrivateKey.String()
+	}
+	if cfg.PendingPrivateKey != nil {
+		doc.Interface.PendingPrivateKey = cfg.PendingPrivateKey.String()
+	}
+	if !cfg.PendingKeysAt.IsZero() {
+		doc.Interface.PendingKeysAt = toRFC3339(cfg.PendingKeysAt)
+	}
+	for _, addr := range cfg.Address {
+		doc.Interface.Address = append(doc.Interface.Address, addr.String())
+	}
+	for _, ip := range cfg.DNS {
+		doc.Interface.DNS = append(doc.Interface.DNS, ip.String())
+	}
+
+	for _, peer := range cfg.Peers {
+		p := peerDoc{PublicKey: peer.PublicKey.String()}
+		opts, hasOpts := cfg.PeerOptions[peer.PublicKey]
+		switch {
+		case hasOpts && opts.PresharedKeyFile != "":
+			p.PresharedKeyFile = opts.PresharedKeyFile
+		case peer.PresharedKey != nil:
+			p.PresharedKey = peer.PresharedKey.String()
+		}
+		for _, allowed := range peer.AllowedIPs {
+			p.AllowedIPs = append(p.AllowedIPs, allowed.String())
+		}
+		if peer.Endpoint != nil {
+			p.Endpoint = peer.Endpoint.String()
+		}
+		if peer.PersistentKeepaliveInterval != nil {
+			p.PersistentKeepalive = toSeconds(*peer.PersistentKeepaliveInterval)
+		}
+		if hasOpts {
+			for _, excluded := range opts.ExcludedIPs {
+				p.ExcludedIPs = append(p.ExcludedIPs, excluded.String())
+			}
+			for _, endpoint := range opts.FallbackEndpoints {
+				p.FallbackEndpoints = append(p.FallbackEndpoints, endpoint.String())
+			}
+			if hc := opts.HealthCheck; hc != nil {
+				p.HealthCheck = &healthCheckDoc{
+					Protocol:  hc.Protocol,
+					Target:    hc.Target,
+					Interval:  toSeconds(hc.Interval),
+					Threshold: hc.Threshold,
+				}
+			}
+			p.RouteMetric = opts.RouteMetric
+			if opts.PendingPublicKey != nil {
+				p.PendingPublicKey = opts.PendingPublicKey.String()
+			}
+			if opts.PendingPresharedKey != nil {
+				p.PendingPresharedKey = opts.PendingPresharedKey.String()
+			}
+		}
+		doc.Peers = append(doc.Peers, p)
+	}
+	return doc
+}
+
+// directive is single wg-quick config line
+type directive struct {
+	key, value string
+}
+
+// fromDoc fills the config going through the same directive parsers as wg-quick config does
+func (cfg *Config) fromDoc(doc *configDoc) error {
+	*cfg = Config{dir: cfg.dir}
+	iface := doc.Interface
+	directives := []directive{
+		{"PrivateKey", iface.PrivateKey},
+		{"PrivateKeyFile", iface.PrivateKeyFile},
+		{"PendingPrivateKey", iface.PendingPrivateKey},
+		{"PendingKeysAt", iface.PendingKeysAt},
+		{"Address", strings.Join(iface.Address, ",")},
+		{"DNS", strings.Join(iface.DNS, ",")},
+	}
+	for _, d := range directives {
+		if d.value == "" {
+			continue
+		}
+		if err := parseInterfaceLine(cfg, d.key, d.value); err != nil {
+			return fmt.Errorf("interface %s: %v", d.key, err)
+		}
+	}
+	cfg.ListenPort = iface.ListenPort
+	cfg.FirewallMark = iface.FwMark
+	cfg.MTU = iface.MTU
+	cfg.Table = iface.Table
+	cfg.PreUp = iface.PreUp
+	cfg.PostUp = iface.PostUp
+	cfg.PreDown = iface.PreDown
+	cfg.PostDown = iface.PostDown
+	cfg.Forwarding = iface.Forwarding
+	cfg.SaveConfig = iface.SaveConfig
+
+	for i, p := range doc.Peers {
+		peerCfg := wgtypes.PeerConfig{}
+		opts := &PeerOptions{}
+		directives := []directive{
+			{"PublicKey", p.PublicKey},
+			{"PresharedKey", p.PresharedKey},
+			{"PresharedKeyFile", p.PresharedKeyFile},
+			{"AllowedIPs", strings.Join(p.AllowedIPs, ",")},
+			{"ExcludedIPs", strings.Join(p.ExcludedIPs, ",")},
+			{"Endpoint", p.Endpoint},
+			{"FallbackEndpoint", strings.Join(p.FallbackEndpoints, ",")},
+			{"PendingPublicKey", p.PendingPublicKey},
+			{"PendingPresharedKey", p.PendingPresharedKey},
+		}
+		if p.PersistentKeepalive != 0 {
+			directives = append(directives, directive{"PersistentKeepalive", strconv.Itoa(p.PersistentKeepalive)})
+		}
+		if hc := p.HealthCheck; hc != nil {
+			if hc.Protocol != "" || hc.Target != "" {
+				directives = append(directives, directive{"HealthCheck", hc.Protocol + " " + hc.Target})
+			}
+			h := opts.healthCheck()
+			h.Interval = time.Duration(hc.Interval) * time.Second
+			h.Threshold = hc.Threshold
+		}
+		opts.RouteMetric = p.RouteMetric
+		for _, d := range directives {
+			if d.value == "" {
+				continue
+			}
+			if err := parsePeerLine(cfg, &peerCfg, opts, d.key, d.value); err != nil {
+				return fmt.Errorf("peer #%d %s: %v", i+1, d.key, err)
+			}
+		}
+		cfg.Peers = append(cfg.Peers, peerCfg)
+		if !opts.empty() {
+			*cfg.Options(peerCfg.PublicKey) = *opts
+		}
+	}
+	return nil
+}
+
+// MarshalJSON encodes the config with the schema described in configDoc
+func (cfg *Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cfg.toDoc())
+}
+
+// UnmarshalJSON decodes the config, unknown fields are rejected like unknown directives
+func (cfg *Config) UnmarshalJSON(b []byte) error {
+	doc := &configDoc{}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(doc); err != nil {
+		return err
+	}
+	return cfg.fromDoc(doc)
+}
+
+// MarshalYAML encodes the config with the same schema as MarshalJSON
+func (cfg *Config) MarshalYAML() (interface{}, error) {
+	return cfg.toDoc(), nil
+}
+
+// UnmarshalYAML decodes the config with the same schema as UnmarshalJSON
+func (cfg *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	doc := &configDoc{}
+	if err := unmarshal(doc); err != nil {
+		return err
+	}
+	return cfg.fromDoc(doc)
+}
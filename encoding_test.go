@@ -0,0 +1,80 @@
+package wgquick
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	for name, text := range testConfigs {
+		for _, format := range []Format{FormatJSON, FormatYAML} {
+			t.Run(name+"/"+string(format), func(t *testing.T) {
+				c := &Config{}
+				require.NoError(t, c.UnmarshalText([]byte(text)))
+				b, err := c.Encode(format)
+				require.NoError(t, err)
+				decoded, err := DecodeConfig(format, "", b)
+				require.NoError(t, err, string(b))
+				assert.Equal(t, text, decoded.String())
+			})
+		}
+	}
+}
+
+func TestEncodingSchema(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(testConfigs["simple"])))
+	b, err := c.Encode(FormatJSON)
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  "interface": {
+    "privateKey": "oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=",
+    "address": [
+      "10.200.100.8/24"
+    ],
+    "dns": [
+      "10.200.100.1"
+    ]
+  },
+  "peers": [
+    {
+      "publicKey": "GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=",
+      "presharedKey": "/UwcSPg38hW/D9Y3tcS1FOV0K1wuURMbS0sesJEP5ak=",
+      "allowedIPs": [
+        "0.0.0.0/0"
+      ],
+      "endpoint": "123.12.12.1:51820"
+    }
+  ]
+}
+`, string(b))
+
+	b, err = c.Encode(FormatYAML)
+	require.NoError(t, err)
+	assert.Equal(t, `interface:
+  privateKey: oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+  address:
+  - 10.200.100.8/24
+  dns:
+  - 10.200.100.1
+peers:
+- publicKey: GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+  presharedKey: /UwcSPg38hW/D9Y3tcS1FOV0K1wuURMbS0sesJEP5ak=
+  allowedIPs:
+  - 0.0.0.0/0
+  endpoint: 123.12.12.1:51820
+`, string(b))
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := DecodeConfig(FormatJSON, "wg0.json", []byte(`{"interface": {"privateKey": "oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=", "listen": 1}}`))
+	assert.EqualError(t, err, `wg0.json: json: unknown field "listen"`)
+
+	_, err = DecodeConfig(FormatYAML, "wg0.yaml", []byte("peers:\n- publicKey: GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=\n  allowedIPs: [10.0.0.0/33]\n"))
+	assert.EqualError(t, err, "wg0.yaml: peer #1 AllowedIPs: cannot parse 10.0.0.0/33: invalid CIDR address: 10.0.0.0/33")
+
+	assert.Equal(t, FormatYAML, FormatFromPath("/etc/wireguard/wg0.yml"))
+	assert.Equal(t, FormatINI, FormatFromPath("/etc/wireguard/wg0.conf"))
+}
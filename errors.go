@@ -0,0 +1,56 @@
+package wgquick
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseError describes single problem in wg-quick config file
+type ParseError struct {
+	// File name, empty when parsing text without file
+	File string
+	// Line is 1-based line number
+	Line int
+	// Column is 1-based column of the value for directive errors, or of the line start for syntax errors
+	Column int
+	// Section is Interface or Peer, empty before the first section
+	Section string
+	// Key is the directive name, empty for syntax errors
+	Key string
+	// Value is the raw directive value
+	Value string
+	// Err is the underlying cause
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	b := &strings.Builder{}
+	if e.File != "" {
+		fmt.Fprintf(b, "%s:", e.File)
+	}
+	fmt.Fprintf(b, "%d:%d: ", e.Line, e.Column)
+	if e.Section != "" {
+		fmt.Fprintf(b, "[%s] ", e.Section)
+	}
+	if e.Key != "" {
+		fmt.Fprintf(b, "%s: ", e.Key)
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+// Unwrap returns the underlying cause
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors are all problems found in a config file
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
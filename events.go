@@ -0,0 +1,225 @@
+package wgquick
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// EventType is the kind of peer connectivity event
+type EventType string
+
+const (
+	// HandshakeEstablished is emitted when the peer completes a handshake after having none or a stale one
+	HandshakeEstablished EventType = "handshake_established"
+	// HandshakeStale is emitted once the last handshake is older than the stale threshold
+	HandshakeStale EventType = "handshake_stale"
+	// EndpointChanged is emitted when the peer roams to a new endpoint
+	EndpointChanged EventType = "endpoint_changed"
+	// PeerAdded is emitted when the peer appears on the device, or when Sync adds it
+	PeerAdded EventType = "peer_added"
+	// PeerRemoved is emitted when the peer disappears from the device, or when Sync removes it
+	PeerRemoved EventType = "peer_removed"
+)
+
+// Event is single peer connectivity event
+type Event struct {
+	Type             EventType  `json:"type"`
+	Time             time.Time  `json:"time"`
+	Interface        string     `json:"interface"`
+	PublicKey        string     `json:"public_key"`
+	Endpoint         string     `json:"endpoint,omitempty"`
+	PreviousEndpoint string     `json:"previous_endpoint,omitempty"`
+	LastHandshake    *time.Time `json:"last_handshake,omitempty"`
+}
+
+// DeviceReader reads single wireguard device. *wgctrl.Client satisfies it
+type DeviceReader interface {
+	Device(name string) (*wgtypes.Device, error)
+}
+
+// Watcher polls the wireguard device and emits peer connectivity events. Peers added or removed by other processes are noticed
+// on the next poll; register it with RegisterSyncObserver to get peer added/removed events from Sync in this process right away
+type Watcher struct {
+	// Interval between device polls, default 5s
+	Interval time.Duration
+	// StaleAfter is handshake age after which HandshakeStale is emitted. Wireguard rehandshakes every 2 minutes while there's traffic
+	StaleAfter time.Duration
+
+	client DeviceReader
+	iface  string
+	log    logrus.FieldLogger
+
+	mu     sync.Mutex // guards events against closing while ObserveSync sends, and synced
+	events chan Event
+	closed bool
+	// synced are peer added/removed events already emitted by ObserveSync, not to be repeated by the next poll
+	synced map[wgtypes.Key]EventType
+
+	peers map[wgtypes.Key]watchedPeer
+}
+
+type watchedPeer struct {
+	endpoint      string
+	lastHandshake time.Time
+	fresh         bool
+}
+
+var _ SyncObserver = (*Watcher)(nil)
+
+// NewWatcher creates watcher for the given interface
+func NewWatcher(client DeviceReader, iface string, logger logrus.FieldLogger) *Watcher {
+	return &Watcher{
+		Interval:   5 * time.Second,
+		StaleAfter: 3 * time.Minute,
+		client:     client,
+		iface:      iface,
+		log:        logger.WithField("iface", iface),
+		events:     make(chan Event, 64),
+		synced:     make(map[wgtypes.Key]EventType),
+	}
+}
+
+func (w *Watcher) interval() time.Duration {
+	if w.Interval > 0 {
+		return w.Interval
+	}
+	return 5 * time.Second
+}
+
+// Events returns the event channel. It's closed once Run returns
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Run polls the device until the context is done or reading the device fails
+func (w *Watcher) Run(ctx context.Context) error {
+	defer func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.closed = true
+		close(w.events)
+	}()
+	ticker := time.NewTicker(w.interval())
+	defer ticker.Stop()
+	for {
+		dev, err := w.client.Device(w.iface)
+		if err != nil {
+			w.log.WithError(err).Error("cannot read device")
+			return err
+		}
+		for _, ev := range w.poll(dev, time.Now()) {
+			select {
+			case w.events <- ev:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// poll compares the device with the previous poll. The first poll only records the baseline
+func (w *Watcher) poll(dev *wgtypes.Device, now time.Time) []Event {
+	first := w.peers == nil
+	current := make(map[wgtypes.Key]watchedPeer, len(dev.Peers))
+	var events []Event
+	for _, peer := range dev.Peers {
+		p := watchedPeer{
+			lastHandshake: peer.LastHandshakeTime,
+			fresh:         !peer.LastHandshakeTime.IsZero() && now.Sub(peer.LastHandshakeTime) < w.StaleAfter,
+		}
+		if peer.Endpoint != nil {
+			p.endpoint = peer.Endpoint.String()
+		}
+		current[peer.PublicKey] = p
+
+		prev, known := w.peers[peer.PublicKey]
+		if first {
+			continue
+		}
+		ev := Event{
+			Time:      now,
+			Interface: w.iface,
+			PublicKey: peer.PublicKey.String(),
+			Endpoint:  p.endpoint,
+		}
+		if !p.lastHandshake.IsZero() {
+			ev.LastHandshake = &p.lastHandshake
+		}
+		if !known && !w.alreadySynced(peer.PublicKey, PeerAdded) {
+			ev := ev
+			ev.Type = PeerAdded
+			events = append(events, ev)
+		}
+		if known && prev.endpoint != "" && prev.endpoint != p.endpoint {
+			ev := ev
+			ev.Type = EndpointChanged
+			ev.PreviousEndpoint = prev.endpoint
+			events = append(events, ev)
+		}
+		switch {
+		case p.fresh && (!known || !prev.fresh):
+			ev.Type = HandshakeEstablished
+			events = append(events, ev)
+		case !p.fresh && known && prev.fresh:
+			ev.Type = HandshakeStale
+			events = append(events, ev)
+		}
+	}
+
+	var removed []string
+	for key := range w.peers {
+		if _, ok := current[key]; !ok && !w.alreadySynced(key, PeerRemoved) {
+			removed = append(removed, key.String())
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		events = append(events, Event{Type: PeerRemoved, Time: now, Interface: w.iface, PublicKey: key})
+	}
+	w.peers = current
+	return events
+}
+
+// alreadySynced reports whether ObserveSync has emitted the event for the peer, forgetting it
+func (w *Watcher) alreadySynced(key wgtypes.Key, typ EventType) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	synced, ok := w.synced[key]
+	delete(w.synced, key)
+	return ok && synced == typ
+}
+
+// ObserveSync implements SyncObserver
+func (w *Watcher) ObserveSync(iface string, changes *DeviceChanges, err error) {
+	if iface != w.iface || changes == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	emit := func(typ EventType, keys []wgtypes.Key) {
+		for _, key := range keys {
+			ev := Event{Type: typ, Time: time.Now(), Interface: iface, PublicKey: key.String()}
+			select {
+			case w.events <- ev:
+				w.synced[key] = typ
+			default:
+				w.log.WithField("peer", ev.PublicKey).Warnf("event buffer full, dropping %s event", typ)
+			}
+		}
+	}
+	emit(PeerAdded, changes.Added)
+	emit(PeerRemoved, changes.Removed)
+}
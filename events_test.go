@@ -0,0 +1,93 @@
+package wgquick
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestWatcherPoll(t *testing.T) {
+	key := mustKey(t)
+	w := NewWatcher(nil, "wg0", logrus.New())
+	w.StaleAfter = time.Minute
+	now := time.Unix(10000, 0)
+	first := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 51820}
+	second := &net.UDPAddr{IP: net.ParseIP("5.6.7.8"), Port: 51820}
+
+	dev := func(handshake time.Time, endpoint *net.UDPAddr) *wgtypes.Device {
+		return &wgtypes.Device{Peers: []wgtypes.Peer{{PublicKey: key, LastHandshakeTime: handshake, Endpoint: endpoint}}}
+	}
+	types := func(events []Event) []EventType {
+		var res []EventType
+		for _, ev := range events {
+			res = append(res, ev.Type)
+		}
+		return res
+	}
+
+	assert.Empty(t, w.poll(dev(time.Time{}, nil), now), "baseline")
+	assert.Equal(t, []EventType{HandshakeEstablished}, types(w.poll(dev(now, first), now)))
+	assert.Empty(t, w.poll(dev(now, first), now.Add(30*time.Second)))
+
+	events := w.poll(dev(now, second), now.Add(40*time.Second))
+	require.Equal(t, []EventType{EndpointChanged}, types(events))
+	assert.Equal(t, first.String(), events[0].PreviousEndpoint)
+	assert.Equal(t, second.String(), events[0].Endpoint)
+
+	assert.Equal(t, []EventType{HandshakeStale}, types(w.poll(dev(now, second), now.Add(2*time.Minute))))
+	assert.Empty(t, w.poll(dev(now, second), now.Add(3*time.Minute)))
+	assert.Equal(t, []EventType{HandshakeEstablished}, types(w.poll(dev(now.Add(3*time.Minute), second), now.Add(3*time.Minute))))
+}
+
+func TestWatcherObserveSync(t *testing.T) {
+	added, removed := mustKey(t), mustKey(t)
+	w := NewWatcher(nil, "wg0", logrus.New())
+	w.ObserveSync("wg1", &DeviceChanges{Added: []wgtypes.Key{added}}, nil)
+	w.ObserveSync("wg0", &DeviceChanges{Added: []wgtypes.Key{added}, Removed: []wgtypes.Key{removed}}, nil)
+	require.Len(t, w.events, 2)
+	assert.Equal(t, PeerAdded, (<-w.events).Type)
+	ev := <-w.events
+	assert.Equal(t, PeerRemoved, ev.Type)
+	assert.Equal(t, removed.String(), ev.PublicKey)
+}
+
+func TestWatcherPeerSet(t *testing.T) {
+	kept, added, removed := mustKey(t), mustKey(t), mustKey(t)
+	w := NewWatcher(nil, "wg0", logrus.New())
+	now := time.Unix(10000, 0)
+	dev := func(keys ...wgtypes.Key) *wgtypes.Device {
+		d := &wgtypes.Device{}
+		for _, key := range keys {
+			d.Peers = append(d.Peers, wgtypes.Peer{PublicKey: key})
+		}
+		return d
+	}
+
+	assert.Empty(t, w.poll(dev(kept, removed), now), "baseline")
+	events := w.poll(dev(kept, added), now)
+	require.Len(t, events, 2)
+	assert.Equal(t, Event{Type: PeerAdded, Time: now, Interface: "wg0", PublicKey: added.String()}, events[0])
+	assert.Equal(t, Event{Type: PeerRemoved, Time: now, Interface: "wg0", PublicKey: removed.String()}, events[1])
+
+	// changes already reported by Sync in this process aren't repeated
+	w.ObserveSync("wg0", &DeviceChanges{Added: []wgtypes.Key{removed}, Removed: []wgtypes.Key{added}}, nil)
+	require.Len(t, w.events, 2)
+	assert.Empty(t, w.poll(dev(kept, removed), now))
+	assert.Empty(t, w.synced)
+}
+
+func TestWatcherDefaults(t *testing.T) {
+	w := NewWatcher(nil, "wg0", logrus.New())
+	w.Interval = 0
+	assert.Equal(t, 5*time.Second, w.interval())
+
+	b, err := json.Marshal(Event{Type: PeerAdded, Time: time.Unix(0, 0).UTC(), Interface: "wg0", PublicKey: "key"})
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"peer_added","time":"1970-01-01T00:00:00Z","interface":"wg0","public_key":"key"}`, string(b))
+}
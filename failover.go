@@ -0,0 +1,151 @@
+package wgquick
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DeviceConfigurer reads and configures wireguard devices. *wgctrl.Client satisfies it
+type DeviceConfigurer interface {
+	DeviceReader
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+}
+
+// EndpointFailover switches peer endpoints between the configured Endpoint and FallbackEndpoints.
+// When the handshake isn't refreshed within Threshold the next endpoint in the list is tried.
+// While running on a fallback endpoint, the preferred Endpoint is retried every RetryPreferred, going back to the fallback
+// unless the preferred one handshakes within Threshold.
+// Only peers with PersistentKeepalive or sending traffic are evaluated, idle peers don't handshake and would look dead
+type EndpointFailover struct {
+	// Interval between device polls
+	Interval time.Duration
+	// Threshold is the handshake age after which the current endpoint is considered dead
+	Threshold time.Duration
+	// RetryPreferred is how long to stay on a working fallback endpoint before retrying the preferred one
+	RetryPreferred time.Duration
+
+	client DeviceConfigurer
+	cfg    *Config
+	iface  string
+	log    logrus.FieldLogger
+
+	peers map[wgtypes.Key]*failoverPeer
+}
+
+type failoverPeer struct {
+	endpoints   []*net.UDPAddr // preferred first
+	current     int
+	switchedAt  time.Time
+	transmitted int64
+	// retrying is set while the preferred endpoint is retried, fallback is the endpoint to go back to if it doesn't handshake
+	retrying bool
+	fallback int
+}
+
+// NewEndpointFailover creates failover monitor for the peers in cfg having FallbackEndpoints
+func NewEndpointFailover(client DeviceConfigurer, cfg *Config, iface string, logger logrus.FieldLogger) *EndpointFailover {
+	return &EndpointFailover{
+		Interval:       10 * time.Second,
+		Threshold:      3 * time.Minute,
+		RetryPreferred: 30 * time.Minute,
+		client:         client,
+		cfg:            cfg,
+		iface:          iface,
+		log:            logger.WithField("iface", iface),
+		peers:          make(map[wgtypes.Key]*failoverPeer),
+	}
+}
+
+// Run monitors the device until the context is done or talking to the device fails
+func (f *EndpointFailover) Run(ctx context.Context) error {
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+	for {
+		dev, err := f.client.Device(f.iface)
+		if err != nil {
+			f.log.WithError(err).Error("cannot read device")
+			return err
+		}
+		if updates := f.check(dev, time.Now()); len(updates) > 0 {
+			if err := f.client.ConfigureDevice(f.iface, wgtypes.Config{Peers: updates}); err != nil {
+				f.log.WithError(err).Error("cannot switch endpoints")
+				return err
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// check returns endpoint updates for the peers which should switch endpoint
+func (f *EndpointFailover) check(dev *wgtypes.Device, now time.Time) []wgtypes.PeerConfig {
+	var updates []wgtypes.PeerConfig
+	for _, peer := range dev.Peers {
+		opts, ok := f.cfg.PeerOptions[peer.PublicKey]
+		if !ok || len(opts.FallbackEndpoints) == 0 {
+			continue
+		}
+		idx := findPeer(f.cfg, peer.PublicKey)
+		if idx < 0 || f.cfg.Peers[idx].Endpoint == nil {
+			continue
+		}
+
+		fp, ok := f.peers[peer.PublicKey]
+		if !ok {
+			fp = &failoverPeer{
+				endpoints:  append([]*net.UDPAddr{f.cfg.Peers[idx].Endpoint}, opts.FallbackEndpoints...),
+				switchedAt: now,
+			}
+			f.peers[peer.PublicKey] = fp
+		}
+		active := peer.PersistentKeepaliveInterval > 0 || peer.TransmitBytes > fp.transmitted
+		fp.transmitted = peer.TransmitBytes
+		if !active {
+			continue
+		}
+
+		healthy := !peer.LastHandshakeTime.IsZero() && now.Sub(peer.LastHandshakeTime) < f.Threshold
+		next := fp.current
+		retry := false
+		switch {
+		case fp.retrying && peer.LastHandshakeTime.After(fp.switchedAt):
+			fp.retrying = false // preferred endpoint is back
+		case fp.retrying && now.Sub(fp.switchedAt) >= f.Threshold:
+			next = fp.fallback
+		case fp.retrying:
+			// handshake on the preferred endpoint still pending, the one from the fallback endpoint doesn't count
+		case !healthy && now.Sub(fp.switchedAt) >= f.Threshold:
+			next = (fp.current + 1) % len(fp.endpoints)
+		case healthy && fp.current != 0 && now.Sub(fp.switchedAt) >= f.RetryPreferred:
+			next = 0
+			retry = true
+		}
+		if next == fp.current {
+			continue
+		}
+
+		f.log.WithFields(map[string]interface{}{
+			"peer":           peer.PublicKey.String(),
+			"from":           fp.endpoints[fp.current].String(),
+			"to":             fp.endpoints[next].String(),
+			"last_handshake": peer.LastHandshakeTime,
+		}).Warn("switching peer endpoint")
+		fp.retrying = retry
+		fp.fallback = fp.current
+		fp.current = next
+		fp.switchedAt = now
+		updates = append(updates, wgtypes.PeerConfig{
+			PublicKey:  peer.PublicKey,
+			UpdateOnly: true,
+			Endpoint:   fp.endpoints[next],
+		})
+	}
+	return updates
+}
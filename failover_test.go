@@ -0,0 +1,70 @@
+package wgquick
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestEndpointFailover(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, cfg.UnmarshalText([]byte(testConfigs["fallback-endpoints"])))
+	key := cfg.Peers[0].PublicKey
+
+	f := NewEndpointFailover(nil, cfg, "wg0", logrus.New())
+	f.Threshold = time.Minute
+	f.RetryPreferred = 10 * time.Minute
+	start := time.Unix(10000, 0)
+	dev := func(handshake time.Time) *wgtypes.Device {
+		return &wgtypes.Device{Peers: []wgtypes.Peer{{PublicKey: key, LastHandshakeTime: handshake, PersistentKeepaliveInterval: 25 * time.Second}, {PublicKey: cfg.Peers[1].PublicKey}}}
+	}
+	endpoint := func(updates []wgtypes.PeerConfig) string {
+		require.Len(t, updates, 1)
+		assert.Equal(t, key, updates[0].PublicKey)
+		assert.True(t, updates[0].UpdateOnly)
+		return updates[0].Endpoint.String()
+	}
+
+	assert.Empty(t, f.check(dev(start), start), "healthy")
+	assert.Empty(t, f.check(dev(start), start.Add(30*time.Second)))
+	assert.Equal(t, "124.12.12.1:51820", endpoint(f.check(dev(start), start.Add(90*time.Second))))
+	assert.Empty(t, f.check(dev(start), start.Add(2*time.Minute)), "give fallback time to handshake")
+	assert.Equal(t, "125.12.12.1:51820", endpoint(f.check(dev(start), start.Add(3*time.Minute))))
+	assert.Equal(t, "123.12.12.1:51820", endpoint(f.check(dev(start), start.Add(4*time.Minute))), "wraps around")
+	assert.Equal(t, "124.12.12.1:51820", endpoint(f.check(dev(start), start.Add(5*time.Minute))))
+
+	recovered := start.Add(6 * time.Minute)
+	assert.Empty(t, f.check(dev(recovered), recovered))
+	retried := recovered.Add(10 * time.Minute)
+	assert.Equal(t, "123.12.12.1:51820", endpoint(f.check(dev(retried), retried)), "back to preferred")
+	assert.Empty(t, f.check(dev(retried), retried.Add(30*time.Second)), "fallback handshake doesn't count for the preferred endpoint")
+	assert.Equal(t, "124.12.12.1:51820", endpoint(f.check(dev(retried), retried.Add(time.Minute))), "preferred still dead, back to the fallback")
+
+	retried = retried.Add(11 * time.Minute)
+	assert.Equal(t, "123.12.12.1:51820", endpoint(f.check(dev(retried), retried)))
+	assert.Empty(t, f.check(dev(retried.Add(time.Second)), retried.Add(time.Minute)), "preferred handshaked")
+	assert.Empty(t, f.check(dev(retried.Add(2*time.Minute)), retried.Add(2*time.Minute)))
+}
+
+func TestEndpointFailoverIdle(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, cfg.UnmarshalText([]byte(testConfigs["fallback-endpoints"])))
+	key := cfg.Peers[0].PublicKey
+
+	f := NewEndpointFailover(nil, cfg, "wg0", logrus.New())
+	f.Threshold = time.Minute
+	start := time.Unix(10000, 0)
+	dev := func(tx int64) *wgtypes.Device {
+		return &wgtypes.Device{Peers: []wgtypes.Peer{{PublicKey: key, LastHandshakeTime: start, TransmitBytes: tx}}}
+	}
+
+	assert.Empty(t, f.check(dev(100), start))
+	assert.Empty(t, f.check(dev(100), start.Add(5*time.Minute)), "no traffic, no handshake expected")
+	updates := f.check(dev(200), start.Add(6*time.Minute))
+	require.Len(t, updates, 1, "sending without handshake")
+	assert.Equal(t, "124.12.12.1:51820", updates[0].Endpoint.String())
+}
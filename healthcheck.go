@@ -0,0 +1,285 @@
+package wgquick
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// HealthCheck is a probe sent through the tunnel to the peer
+type HealthCheck struct {
+	// Protocol is either icmp or tcp
+	Protocol string
+	// Target is IP address for icmp, or ip:port for tcp probe
+	Target string
+	// Interval between probes, default 10s
+	Interval time.Duration
+	// Threshold is number of consecutive failures after which the peer is unhealthy, default 3
+	Threshold int
+}
+
+func (hc *HealthCheck) interval() time.Duration {
+	if hc.Interval > 0 {
+		return hc.Interval
+	}
+	return 10 * time.Second
+}
+
+func (hc *HealthCheck) threshold() int {
+	if hc.Threshold > 0 {
+		return hc.Threshold
+	}
+	return 3
+}
+
+// HealthChecker probes peers having HealthCheck. Once a peer fails Threshold consecutive probes its AllowedIPs routes are withdrawn,
+// letting backup routes with higher metric take over. Routes are put back after the first successful probe.
+// Unhealthy peers are recorded in the interface State, so Sync doesn't reinstall their routes
+type HealthChecker struct {
+	// Timeout for single probe
+	Timeout time.Duration
+
+	cfg   *Config
+	iface string
+	log   logrus.FieldLogger
+	probe func(ctx context.Context, iface string, hc *HealthCheck) error
+	apply func(unhealthy map[wgtypes.Key]bool) error
+
+	mu       sync.Mutex
+	failures map[wgtypes.Key]int
+}
+
+// NewHealthChecker creates health checker for peers of the given interface
+func NewHealthChecker(cfg *Config, iface string, logger logrus.FieldLogger) *HealthChecker {
+	h := &HealthChecker{
+		Timeout:  2 * time.Second,
+		cfg:      cfg,
+		iface:    iface,
+		log:      logger.WithField("iface", iface),
+		probe:    probe,
+		failures: make(map[wgtypes.Key]int),
+	}
+	h.apply = h.applyRoutes
+	return h
+}
+
+// Run probes all peers having a HealthCheck until the context is done. Routes withdrawn from unhealthy peers are installed
+// back when it returns, nothing keeps checking them anymore
+func (h *HealthChecker) Run(ctx context.Context) error {
+	wg := sync.WaitGroup{}
+	for _, peer := range h.cfg.Peers {
+		opts, ok := h.cfg.PeerOptions[peer.PublicKey]
+		if !ok || opts.HealthCheck == nil || opts.HealthCheck.Protocol == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(key wgtypes.Key, hc *HealthCheck) {
+			defer wg.Done()
+			ticker := time.NewTicker(hc.interval())
+			defer ticker.Stop()
+			for {
+				probeCtx, cancel := context.WithTimeout(ctx, h.Timeout)
+				err := h.probe(probeCtx, h.iface, hc)
+				cancel()
+				if err := h.record(key, hc, err); err != nil {
+					h.log.WithError(err).Error("cannot apply peer health")
+				}
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(peer.PublicKey, opts.HealthCheck)
+	}
+	wg.Wait()
+	if err := h.reset(); err != nil {
+		h.log.WithError(err).Error("cannot restore routes of unhealthy peers")
+	}
+	return ctx.Err()
+}
+
+// reset forgets the probe failures, clearing the unhealthy peers
+func (h *HealthChecker) reset() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	unhealthy := h.unhealthy()
+	h.failures = make(map[wgtypes.Key]int)
+	if len(unhealthy) == 0 {
+		return nil
+	}
+	h.log.Info("health checks stopped, installing routes of unhealthy peers")
+	return h.apply(map[wgtypes.Key]bool{})
+}
+
+// record accounts the probe result and applies routes if the peer health changed
+func (h *HealthChecker) record(key wgtypes.Key, hc *HealthCheck, probeErr error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	log := h.log.WithField("peer", key.String())
+
+	wasHealthy := h.failures[key] < hc.threshold()
+	if probeErr == nil {
+		h.failures[key] = 0
+	} else {
+		h.failures[key]++
+		log.WithError(probeErr).WithField("failures", h.failures[key]).Debug("health check failed")
+	}
+	healthy := h.failures[key] < hc.threshold()
+	if healthy == wasHealthy {
+		return nil
+	}
+
+	if healthy {
+		log.Info("peer recovered, installing routes")
+	} else {
+		log.WithError(probeErr).Warn("peer unhealthy, withdrawing routes")
+	}
+	return h.apply(h.unhealthy())
+}
+
+// unhealthy returns the peers whose failures reached their threshold. Requires h.mu
+func (h *HealthChecker) unhealthy() map[wgtypes.Key]bool {
+	unhealthy := make(map[wgtypes.Key]bool)
+	for peer, failures := range h.failures {
+		if opts, ok := h.cfg.PeerOptions[peer]; ok && opts.HealthCheck != nil && failures >= opts.HealthCheck.threshold() {
+			unhealthy[peer] = true
+		}
+	}
+	return unhealthy
+}
+
+func (h *HealthChecker) applyRoutes(unhealthy map[wgtypes.Key]bool) error {
+	lock, err := lockInterface(h.iface, "healthcheck", lockTimeout(h.cfg))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	link, err := netlink.LinkByName(h.iface)
+	if err != nil {
+		return err
+	}
+	st, err := LoadState(h.iface)
+	if err != nil {
+		return err
+	}
+	st.Unhealthy = nil
+	for key := range unhealthy {
+		st.Unhealthy = append(st.Unhealthy, key.String())
+	}
+	if err := st.Save(h.iface); err != nil {
+		return err
+	}
+	return SyncRoutes(h.cfg, link, routedAllowedIPs(h.cfg, st), h.log)
+}
+
+// routedAllowedIPs returns AllowedIPs of all peers which aren't marked unhealthy in the state
+func routedAllowedIPs(cfg *Config, st *State) []net.IPNet {
+	unhealthy := make(map[string]bool, len(st.Unhealthy))
+	for _, key := range st.Unhealthy {
+		unhealthy[key] = true
+	}
+	var routes []net.IPNet
+	for _, peer := range cfg.Peers {
+		if unhealthy[peer.PublicKey.String()] {
+			continue
+		}
+		routes = append(routes, cfg.allowedIPs(peer)...)
+	}
+	return routes
+}
+
+// probe runs single health check bound to the wireguard interface, so it goes through the tunnel even when the peer routes are withdrawn
+func probe(ctx context.Context, iface string, hc *HealthCheck) error {
+	bind := func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+
+	switch hc.Protocol {
+	case "tcp":
+		d := net.Dialer{Control: bind}
+		conn, err := d.DialContext(ctx, "tcp", hc.Target)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "icmp":
+		return probeICMP(ctx, hc.Target, bind)
+	default:
+		return fmt.Errorf("unknown health check protocol %s", hc.Protocol)
+	}
+}
+
+func probeICMP(ctx context.Context, target string, bind func(network, address string, c syscall.RawConn) error) error {
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return fmt.Errorf("cannot parse IP %s", target)
+	}
+	network, proto := "ip4:icmp", 1
+	var echo, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, proto = "ip6:ipv6-icmp", 58
+		echo, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	lc := net.ListenConfig{Control: bind}
+	conn, err := lc.ListenPacket(ctx, network, "")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	id := os.Getpid() & 0xffff
+	msg, err := (&icmp.Message{
+		Type: echo,
+		Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("wg-quick-go")},
+	}).Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(msg, &net.IPAddr{IP: ip}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if !from.(*net.IPAddr).IP.Equal(ip) {
+			continue
+		}
+		resp, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		if body, ok := resp.Body.(*icmp.Echo); ok && resp.Type == reply && body.ID == id {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package wgquick
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestHealthChecker(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, cfg.UnmarshalText([]byte(testConfigs["health-check"])))
+	tcpPeer, icmpPeer := cfg.Peers[0].PublicKey, cfg.Peers[1].PublicKey
+	tcpCheck, icmpCheck := cfg.PeerOptions[tcpPeer].HealthCheck, cfg.PeerOptions[icmpPeer].HealthCheck
+	require.Equal(t, 2, tcpCheck.threshold())
+	require.Equal(t, 3, icmpCheck.threshold())
+
+	h := NewHealthChecker(cfg, "wg0", logrus.New())
+	var applied []map[wgtypes.Key]bool
+	h.apply = func(unhealthy map[wgtypes.Key]bool) error {
+		applied = append(applied, unhealthy)
+		return nil
+	}
+	failed := errors.New("timeout")
+
+	require.NoError(t, h.record(tcpPeer, tcpCheck, failed))
+	require.NoError(t, h.record(icmpPeer, icmpCheck, failed))
+	assert.Empty(t, applied, "below threshold")
+	require.NoError(t, h.record(tcpPeer, tcpCheck, failed))
+	require.NoError(t, h.record(tcpPeer, tcpCheck, failed))
+	assert.Equal(t, []map[wgtypes.Key]bool{{tcpPeer: true}}, applied)
+	require.NoError(t, h.record(tcpPeer, tcpCheck, nil))
+	assert.Equal(t, []map[wgtypes.Key]bool{{tcpPeer: true}, {}}, applied)
+
+	st := &State{Unhealthy: []string{tcpPeer.String()}}
+	assert.Equal(t, "10.192.122.4/32, 172.16.0.0/12", FormatCIDRs(routedAllowedIPs(cfg, st)))
+}
+
+func TestHealthCheckerRunResets(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, cfg.UnmarshalText([]byte(testConfigs["health-check"])))
+	tcpPeer := cfg.Peers[0].PublicKey
+
+	h := NewHealthChecker(cfg, "wg0", logrus.New())
+	var applied []map[wgtypes.Key]bool
+	h.apply = func(unhealthy map[wgtypes.Key]bool) error {
+		applied = append(applied, unhealthy)
+		return nil
+	}
+	h.probe = func(ctx context.Context, iface string, hc *HealthCheck) error {
+		return errors.New("timeout")
+	}
+	h.failures[tcpPeer] = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, h.Run(ctx))
+	assert.Equal(t, []map[wgtypes.Key]bool{{}}, applied, "routes installed back")
+	assert.Empty(t, h.failures)
+
+	applied = nil
+	assert.Equal(t, context.Canceled, h.Run(ctx))
+	assert.Empty(t, applied, "nothing unhealthy")
+}
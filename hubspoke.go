@@ -0,0 +1,198 @@
+package wgquick
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// HubRouteMetricStep is the route metric difference between consecutive hubs of a spoke, the first hub in the inventory is preferred
+const HubRouteMetricStep = 100
+
+// maxIfaceName is the longest linux interface name, IFNAMSIZ without the terminating NUL
+const maxIfaceName = 15
+
+// HubAndSpoke generates configs for the hub and spoke topology. Hubs have every spoke as a peer and forward between them.
+// Spokes have only the hub as a peer, with AllowedIPs covering the whole overlay (all tunnel subnets and node subnets but their own),
+// so spokes reach each other through the hub. keys are private keys by node name, see LoadOrCreateKeys.
+//
+// Wireguard can't route the same AllowedIPs to two peers of one interface, so with several hubs every spoke gets one interface per hub,
+// named <spoke>-<hub>, holding its tunnel address as /32 (/128) and listening on the spoke port + index of the hub if it has an endpoint. Hub peers get increasing RouteMetric in inventory order and
+// a HealthCheck of the hub tunnel address, so the first hub is preferred while it answers, see HealthChecker.
+// Hubs health check their spokes the same way and are linked to each other by <hub>-<hub> interfaces routing the overlay
+// with RouteMetric HubRouteMetricStep, listening on the hub port + 1 + index of the other hub. Spokes which failed over to another hub
+// are reached over these links. With single hub the spoke config is named <spoke>
+func (inv *Inventory) HubAndSpoke(keys map[string]wgtypes.Key) (map[string]*Config, error) {
+	var hubs, spokes []*InventoryNode
+	for i := range inv.Nodes {
+		node := &inv.Nodes[i]
+		if _, ok := keys[node.Name]; !ok {
+			return nil, fmt.Errorf("node %s: missing key", node.Name)
+		}
+		if !node.Hub {
+			spokes = append(spokes, node)
+			continue
+		}
+		if node.Endpoint == "" {
+			return nil, fmt.Errorf("hub %s: missing endpoint, spokes can't reach it", node.Name)
+		}
+		hubs = append(hubs, node)
+	}
+	if len(hubs) == 0 {
+		return nil, fmt.Errorf("no hub in the inventory")
+	}
+	redundant := len(hubs) > 1
+
+	var overlay []net.IPNet
+	for _, node := range inv.Nodes {
+		addr, err := node.address()
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %v", node.Name, err)
+		}
+		overlay = append(overlay, net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask})
+		if len(node.Subnets) > 0 {
+			subnets, err := ParseCIDRs(strings.Join(node.Subnets, ","))
+			if err != nil {
+				return nil, fmt.Errorf("node %s: %v", node.Name, err)
+			}
+			overlay = append(overlay, subnets...)
+		}
+	}
+	overlay = AggregateCIDRs(overlay)
+
+	configs := make(map[string]*Config, len(hubs)*(len(spokes)+len(hubs)))
+	add := func(name string, cfg *Config) error {
+		if len(name) > maxIfaceName {
+			return fmt.Errorf("interface name %s longer than %d characters", name, maxIfaceName)
+		}
+		if _, ok := configs[name]; ok {
+			return fmt.Errorf("config name %s already used", name)
+		}
+		configs[name] = cfg
+		return nil
+	}
+
+	for i, hub := range hubs {
+		cfg, err := inv.interfaceConfig(hub, keys[hub.Name])
+		if err != nil {
+			return nil, fmt.Errorf("hub %s: %v", hub.Name, err)
+		}
+		cfg.Forwarding = true
+		for _, spoke := range spokes {
+			peer, err := inv.peerConfig(spoke, keys[spoke.Name])
+			if err != nil {
+				return nil, fmt.Errorf("spoke %s: %v", spoke.Name, err)
+			}
+			if peer.Endpoint != nil {
+				// the spoke interface for this hub, see below
+				endpoint := *peer.Endpoint
+				endpoint.Port += i
+				peer.Endpoint = &endpoint
+			}
+			cfg.Peers = append(cfg.Peers, peer)
+			if redundant {
+				// withdrawn route of a spoke homed on another hub falls back to the hub link
+				cfg.Options(peer.PublicKey).HealthCheck = tunnelHealthCheck(peer)
+			}
+		}
+		if err := add(hub.Name, cfg); err != nil {
+			return nil, fmt.Errorf("hub %s: %v", hub.Name, err)
+		}
+
+		for j, other := range hubs {
+			if i == j {
+				continue
+			}
+			link, err := inv.hubLink(hub, i, other, j, keys, overlay)
+			if err != nil {
+				return nil, fmt.Errorf("hub %s: %v", hub.Name, err)
+			}
+			if err := add(hub.Name+"-"+other.Name, link); err != nil {
+				return nil, fmt.Errorf("hub %s: %v", hub.Name, err)
+			}
+		}
+	}
+
+	for _, spoke := range spokes {
+		own, err := inv.peerConfig(spoke, keys[spoke.Name])
+		if err != nil {
+			return nil, fmt.Errorf("spoke %s: %v", spoke.Name, err)
+		}
+		for i, hub := range hubs {
+			cfg, err := inv.interfaceConfig(spoke, keys[spoke.Name])
+			if err != nil {
+				return nil, fmt.Errorf("spoke %s: %v", spoke.Name, err)
+			}
+			if cfg.ListenPort != nil {
+				// spoke with public endpoint listens for the hub handshakes, on the spoke port + hub index
+				port := *cfg.ListenPort + i
+				cfg.ListenPort = &port
+			}
+			peer, err := inv.peerConfig(hub, keys[hub.Name])
+			if err != nil {
+				return nil, fmt.Errorf("hub %s: %v", hub.Name, err)
+			}
+			// own subnets stay on the spoke LAN, own tunnel address is local anyway
+			peer.AllowedIPs = SubtractCIDRs(overlay, own.AllowedIPs[1:])
+			if spoke.Endpoint == "" {
+				keepalive := time.Duration(inv.keepalive()) * time.Second
+				peer.PersistentKeepaliveInterval = &keepalive
+			}
+			cfg.Peers = []wgtypes.PeerConfig{peer}
+
+			name := spoke.Name
+			if redundant {
+				name = spoke.Name + "-" + hub.Name
+				cfg.Address = own.AllowedIPs[:1]
+				opts := cfg.Options(peer.PublicKey)
+				opts.RouteMetric = (i + 1) * HubRouteMetricStep
+				hubPeer, err := inv.peerConfig(hub, keys[hub.Name])
+				if err != nil {
+					return nil, fmt.Errorf("hub %s: %v", hub.Name, err)
+				}
+				opts.HealthCheck = tunnelHealthCheck(hubPeer)
+			}
+			if err := add(name, cfg); err != nil {
+				return nil, fmt.Errorf("spoke %s: %v", spoke.Name, err)
+			}
+		}
+	}
+	return configs, nil
+}
+
+// hubLink creates the config of the interface linking hub to the other hub, routing the overlay but the hub itself over it
+func (inv *Inventory) hubLink(hub *InventoryNode, hubIdx int, other *InventoryNode, otherIdx int, keys map[string]wgtypes.Key, overlay []net.IPNet) (*Config, error) {
+	cfg, err := inv.interfaceConfig(hub, keys[hub.Name])
+	if err != nil {
+		return nil, err
+	}
+	own, err := inv.peerConfig(hub, keys[hub.Name])
+	if err != nil {
+		return nil, err
+	}
+	cfg.Address = own.AllowedIPs[:1]
+	cfg.DNS = nil
+	cfg.Forwarding = true
+	port := *cfg.ListenPort + 1 + otherIdx
+	cfg.ListenPort = &port
+
+	peer, err := inv.peerConfig(other, keys[other.Name])
+	if err != nil {
+		return nil, err
+	}
+	endpoint := *peer.Endpoint
+	endpoint.Port += 1 + hubIdx
+	peer.Endpoint = &endpoint
+	peer.AllowedIPs = SubtractCIDRs(overlay, own.AllowedIPs)
+	cfg.Peers = []wgtypes.PeerConfig{peer}
+	cfg.Options(peer.PublicKey).RouteMetric = HubRouteMetricStep
+	return cfg, nil
+}
+
+// tunnelHealthCheck pings the tunnel address of the peer created by peerConfig
+func tunnelHealthCheck(peer wgtypes.PeerConfig) *HealthCheck {
+	return &HealthCheck{Protocol: "icmp", Target: peer.AllowedIPs[0].IP.String()}
+}
@@ -0,0 +1,176 @@
+package wgquick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubAndSpoke(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	inv := writeInventory(t, dir, `nodes:
+- name: hub
+  endpoint: 127.0.0.1
+  address: 10.10.0.1/24
+  hub: true
+- name: office
+  endpoint: 127.0.0.2
+  address: 10.10.0.2/24
+  subnets: [192.168.1.0/24]
+- name: laptop
+  address: 10.10.0.3/24
+`)
+	keys, err := inv.LoadOrCreateKeys(filepath.Join(dir, "keys"))
+	require.NoError(t, err)
+	configs, err := inv.HubAndSpoke(keys)
+	require.NoError(t, err)
+	require.Len(t, configs, 3)
+
+	pub := func(name string) string {
+		return keys[name].PublicKey().String()
+	}
+	assert.Equal(t, `[Interface]
+Address = 10.10.0.1/24
+PrivateKey = `+keys["hub"].String()+`
+ListenPort = 51820
+Forwarding = true
+
+[Peer]
+PublicKey = `+pub("office")+`
+AllowedIPs = 10.10.0.2/32, 192.168.1.0/24
+Endpoint = 127.0.0.2:51820
+
+[Peer]
+PublicKey = `+pub("laptop")+`
+AllowedIPs = 10.10.0.3/32
+`, configs["hub"].String())
+	assert.Equal(t, `[Interface]
+Address = 10.10.0.2/24
+PrivateKey = `+keys["office"].String()+`
+ListenPort = 51820
+
+[Peer]
+PublicKey = `+pub("hub")+`
+AllowedIPs = 10.10.0.0/24
+Endpoint = 127.0.0.1:51820
+`, configs["office"].String())
+	assert.Equal(t, `[Interface]
+Address = 10.10.0.3/24
+PrivateKey = `+keys["laptop"].String()+`
+
+[Peer]
+PublicKey = `+pub("hub")+`
+AllowedIPs = 10.10.0.0/24, 192.168.1.0/24
+PersistentKeepalive = 25
+Endpoint = 127.0.0.1:51820
+`, configs["laptop"].String())
+	for name, cfg := range configs {
+		assert.NoError(t, cfg.Validate().Err(), name)
+	}
+}
+
+func TestHubAndSpokeRedundantHubs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	inv := writeInventory(t, dir, `nodes:
+- name: fra
+  endpoint: 127.0.0.1
+  address: 10.10.0.1/24
+  hub: true
+- name: ams
+  endpoint: 127.0.0.2
+  address: 10.10.0.2/24
+  hub: true
+- name: laptop
+  address: 10.10.0.3/24
+- name: office
+  endpoint: 127.0.0.3
+  address: 10.10.0.4/24
+`)
+	keys, err := inv.LoadOrCreateKeys(filepath.Join(dir, "keys"))
+	require.NoError(t, err)
+	configs, err := inv.HubAndSpoke(keys)
+	require.NoError(t, err)
+	require.Len(t, configs, 8)
+	assert.Len(t, configs["fra"].Peers, 2)
+	assert.Len(t, configs["ams"].Peers, 2)
+	assert.Equal(t, &HealthCheck{Protocol: "icmp", Target: "10.10.0.3"}, configs["fra"].Options(keys["laptop"].PublicKey()).HealthCheck)
+
+	assert.Equal(t, `[Interface]
+Address = 10.10.0.1/32
+PrivateKey = `+keys["fra"].String()+`
+ListenPort = 51822
+Forwarding = true
+
+[Peer]
+PublicKey = `+keys["ams"].PublicKey().String()+`
+AllowedIPs = 10.10.0.0/32, 10.10.0.2/31, 10.10.0.4/30, 10.10.0.8/29, 10.10.0.16/28, 10.10.0.32/27, 10.10.0.64/26, 10.10.0.128/25
+Endpoint = 127.0.0.2:51821
+RouteMetric = 100
+`, configs["fra-ams"].String())
+	assert.Equal(t, 51821, *configs["ams-fra"].ListenPort)
+	assert.Equal(t, 51822, configs["ams-fra"].Peers[0].Endpoint.Port)
+
+	assert.Equal(t, `[Interface]
+Address = 10.10.0.3/32
+PrivateKey = `+keys["laptop"].String()+`
+
+[Peer]
+PublicKey = `+keys["ams"].PublicKey().String()+`
+AllowedIPs = 10.10.0.0/24
+PersistentKeepalive = 25
+Endpoint = 127.0.0.2:51820
+HealthCheck = icmp 10.10.0.2
+RouteMetric = 200
+`, configs["laptop-ams"].String())
+	assert.Equal(t, 100, configs["laptop-fra"].Options(keys["fra"].PublicKey()).RouteMetric)
+
+	// spoke with endpoint listens for every hub on its own port, the hubs handshake to it
+	assert.Equal(t, 51820, *configs["office-fra"].ListenPort)
+	assert.Equal(t, 51821, *configs["office-ams"].ListenPort)
+	assert.Equal(t, "127.0.0.3:51820", configs["fra"].Peers[1].Endpoint.String())
+	assert.Equal(t, "127.0.0.3:51821", configs["ams"].Peers[1].Endpoint.String())
+	assert.Nil(t, configs["laptop-fra"].ListenPort)
+}
+
+func TestHubAndSpokeErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	inv := writeInventory(t, dir, testInventory)
+	keys, err := inv.LoadOrCreateKeys(filepath.Join(dir, "keys"))
+	require.NoError(t, err)
+	_, err = inv.HubAndSpoke(keys)
+	assert.EqualError(t, err, "no hub in the inventory")
+
+	inv = writeInventory(t, dir, testInventory+"  hub: true\n")
+	_, err = inv.HubAndSpoke(keys)
+	assert.EqualError(t, err, "hub laptop: missing endpoint, spokes can't reach it")
+
+	inv = writeInventory(t, dir, `nodes:
+- name: fra
+  endpoint: 127.0.0.1
+  address: 10.10.0.1/24
+  hub: true
+- name: ams
+  endpoint: 127.0.0.2
+  address: 10.10.0.2/24
+  hub: true
+- name: branch-paris
+  address: 10.10.0.3/24
+`)
+	keys, err = inv.LoadOrCreateKeys(filepath.Join(dir, "keys"))
+	require.NoError(t, err)
+	_, err = inv.HubAndSpoke(keys)
+	assert.EqualError(t, err, "spoke branch-paris: interface name branch-paris-fra longer than 15 characters")
+}
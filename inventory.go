@@ -0,0 +1,225 @@
+package wgquick
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultListenPort is used for nodes with public endpoint when neither the node nor the inventory sets the port
+const DefaultListenPort = 51820
+
+// DefaultPersistentKeepalive is used for nodes without public endpoint when the inventory doesn't set it
+const DefaultPersistentKeepalive = 25
+
+// Inventory describes nodes of generated topologies
+type Inventory struct {
+	// ListenPort of nodes with public endpoint, unless set per node or in the endpoint. Default DefaultListenPort
+	ListenPort int `yaml:"listenPort,omitempty"`
+	// PersistentKeepalive in seconds, set by nodes without public endpoint. Default DefaultPersistentKeepalive
+	PersistentKeepalive int `yaml:"persistentKeepalive,omitempty"`
+	// DNS servers set on every node
+	DNS []string `yaml:"dns,omitempty"`
+	// Nodes in the order they're added as peers
+	Nodes []InventoryNode `yaml:"nodes"`
+}
+
+// InventoryNode is single machine in the inventory
+type InventoryNode struct {
+	// Name is unique node name, used for its config and key file names
+	Name string `yaml:"name"`
+	// Endpoint is public host or host:port the other nodes connect to. Empty for nodes behind NAT
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// ListenPort overrides the inventory ListenPort
+	ListenPort int `yaml:"listenPort,omitempty"`
+	// Address is the tunnel address with the overlay subnet mask, e.g. 10.10.0.1/24
+	Address string `yaml:"address"`
+	// Subnets are private networks behind the node, routed to it by the other nodes
+	Subnets []string `yaml:"subnets,omitempty"`
+	// Hub marks the node as hub of the hub and spoke topology, ignored by the full mesh
+	Hub bool `yaml:"hub,omitempty"`
+}
+
+// ReadInventory reads and checks YAML inventory file
+func ReadInventory(path string) (*Inventory, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{}
+	if err := yaml.UnmarshalStrict(b, inv); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := inv.check(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return inv, nil
+}
+
+func (inv *Inventory) check() error {
+	names := make(map[string]bool, len(inv.Nodes))
+	addresses := make(map[string]string, len(inv.Nodes))
+	subnets := make(map[string]string)
+	// subnets of every node as AllowedIPs of a peer, to find the overlapping ones like Validate does
+	routed := make([]wgtypes.PeerConfig, len(inv.Nodes))
+	for i, node := range inv.Nodes {
+		if node.Name == "" || strings.ContainsAny(node.Name, "/\\") {
+			return fmt.Errorf("node #%d: invalid name %q", i+1, node.Name)
+		}
+		if names[node.Name] {
+			return fmt.Errorf("node %s: duplicate name", node.Name)
+		}
+		names[node.Name] = true
+		addr, err := node.address()
+		if err != nil {
+			return fmt.Errorf("node %s: %v", node.Name, err)
+		}
+		if other, ok := addresses[addr.IP.String()]; ok {
+			return fmt.Errorf("node %s: address %s already used by node %s", node.Name, addr.IP, other)
+		}
+		addresses[addr.IP.String()] = node.Name
+		if len(node.Subnets) > 0 {
+			nets, err := ParseCIDRs(strings.Join(node.Subnets, ","))
+			if err != nil {
+				return fmt.Errorf("node %s: %v", node.Name, err)
+			}
+			for _, n := range nets {
+				network := net.IPNet{IP: n.IP.Mask(n.Mask), Mask: n.Mask}
+				if other, ok := subnets[network.String()]; ok && other != node.Name {
+					return fmt.Errorf("node %s: subnet %s already routed to node %s", node.Name, network.String(), other)
+				}
+				subnets[network.String()] = node.Name
+			}
+			routed[i].AllowedIPs = nets
+		}
+	}
+
+	overlaps := allowedIPsOverlaps(routed)
+	pairs := overlappingPairs(overlaps)
+	if len(pairs) > 0 {
+		pair := pairs[0]
+		return fmt.Errorf("node %s: subnets overlap with subnets of node %s on %s", inv.Nodes[pair[1]].Name, inv.Nodes[pair[0]].Name, FormatCIDRs(AggregateCIDRs(overlaps[pair])))
+	}
+	return nil
+}
+
+func (inv *Inventory) listenPort(node *InventoryNode) int {
+	switch {
+	case node.ListenPort != 0:
+		return node.ListenPort
+	case inv.ListenPort != 0:
+		return inv.ListenPort
+	default:
+		return DefaultListenPort
+	}
+}
+
+func (inv *Inventory) keepalive() int {
+	if inv.PersistentKeepalive != 0 {
+		return inv.PersistentKeepalive
+	}
+	return DefaultPersistentKeepalive
+}
+
+// interfaceConfig creates the node config without peers
+func (inv *Inventory) interfaceConfig(node *InventoryNode, key wgtypes.Key) (*Config, error) {
+	addr, err := node.address()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{Address: []net.IPNet{addr}}
+	cfg.PrivateKey = &key
+	if node.Endpoint != "" {
+		port := inv.listenPort(node)
+		if _, p, err := net.SplitHostPort(node.Endpoint); err == nil {
+			if port, err = strconv.Atoi(p); err != nil {
+				return nil, fmt.Errorf("invalid endpoint port %s", p)
+			}
+		}
+		cfg.ListenPort = &port
+	}
+	for _, s := range inv.DNS {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("cannot parse DNS %s", s)
+		}
+		cfg.DNS = append(cfg.DNS, ip)
+	}
+	return cfg, nil
+}
+
+// peerConfig creates the peer section other nodes use for the node, with AllowedIPs of its tunnel address and subnets
+func (inv *Inventory) peerConfig(node *InventoryNode, key wgtypes.Key) (wgtypes.PeerConfig, error) {
+	peer := wgtypes.PeerConfig{PublicKey: key.PublicKey()}
+	addr, err := node.address()
+	if err != nil {
+		return peer, err
+	}
+	_, bits := addr.Mask.Size()
+	peer.AllowedIPs = []net.IPNet{{IP: addr.IP, Mask: net.CIDRMask(bits, bits)}}
+	if len(node.Subnets) > 0 {
+		subnets, err := ParseCIDRs(strings.Join(node.Subnets, ","))
+		if err != nil {
+			return peer, err
+		}
+		peer.AllowedIPs = append(peer.AllowedIPs, subnets...)
+	}
+	if node.Endpoint != "" {
+		if peer.Endpoint, err = clientEndpoint(node.Endpoint, intPtr(inv.listenPort(node))); err != nil {
+			return peer, err
+		}
+	}
+	return peer, nil
+}
+
+func (node *InventoryNode) address() (net.IPNet, error) {
+	ip, n, err := net.ParseCIDR(node.Address)
+	if err != nil {
+		return net.IPNet{}, err
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return net.IPNet{IP: ip, Mask: n.Mask}, nil
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// LoadOrCreateKey reads private key of the node from <dir>/<name>.key, generating and saving a new one if there's none.
+// The file holds base64 key like wg genkey output, readable only by the owner
+func LoadOrCreateKey(dir string, name string) (wgtypes.Key, error) {
+	path := filepath.Join(dir, name+".key")
+	key, err := ReadKeyFile(path)
+	if !os.IsNotExist(err) {
+		return key, err
+	}
+	if key, err = wgtypes.GeneratePrivateKey(); err != nil {
+		return key, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return key, err
+	}
+	return key, WriteKeyFile(path, key)
+}
+
+// LoadOrCreateKeys loads or creates keys of all inventory nodes, see LoadOrCreateKey
+func (inv *Inventory) LoadOrCreateKeys(dir string) (map[string]wgtypes.Key, error) {
+	keys := make(map[string]wgtypes.Key, len(inv.Nodes))
+	for _, node := range inv.Nodes {
+		key, err := LoadOrCreateKey(dir, node.Name)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %v", node.Name, err)
+		}
+		keys[node.Name] = key
+	}
+	return keys, nil
+}
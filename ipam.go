@@ -0,0 +1,151 @@
+package wgquick
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrPoolExhausted is returned when the pools have no free host address left
+var ErrPoolExhausted = errors.New("no free address left in the pool")
+
+// IPAM allocates peer tunnel addresses from the interface Address subnets of a server config.
+// Server addresses and peer AllowedIPs within the pools are used. AllowedIPs covering a whole pool, like 0.0.0.0/0
+// of a gateway peer, don't make its addresses used. Network addresses and IPv4 broadcast addresses are never handed out
+type IPAM struct {
+	peers    int
+	pools    []net.IPNet
+	owners   []addressOwner
+	reserved []net.IPNet
+}
+
+// addressOwner is an address taken by the interface (peer -1) or a peer
+type addressOwner struct {
+	net  net.IPNet
+	peer int
+}
+
+// IPAMConflict is an address taken more than once
+type IPAMConflict struct {
+	Address net.IPNet
+	// Peers are indexes into Config.Peers, -1 for the interface itself.
+	// Addresses handed out by the IPAM count as peers appended to Config.Peers in allocation order
+	Peers []int
+	// Reserved is set if the address is in a reserved range
+	Reserved bool
+}
+
+// NewIPAM creates allocator for the server config. The config isn't modified, add the allocated addresses to it yourself
+func NewIPAM(cfg *Config) *IPAM {
+	a := &IPAM{peers: len(cfg.Peers)}
+	for _, addr := range cfg.Address {
+		_, bits := addr.Mask.Size()
+		a.pools = append(a.pools, net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask})
+		a.owners = append(a.owners, addressOwner{net: net.IPNet{IP: addr.IP, Mask: net.CIDRMask(bits, bits)}, peer: -1})
+	}
+	for i, peer := range cfg.Peers {
+		for _, allowed := range cfg.allowedIPs(peer) {
+			if a.inPools(allowed) {
+				a.owners = append(a.owners, addressOwner{net: allowed, peer: i})
+			}
+		}
+	}
+	return a
+}
+
+// Reserve excludes the ranges from allocation, e.g. addresses of static infrastructure in the subnet
+func (a *IPAM) Reserve(nets ...net.IPNet) {
+	a.reserved = append(a.reserved, nets...)
+}
+
+// Next returns the first free host address of the given family, with the pool mask, and marks it used
+func (a *IPAM) Next(ipv6 bool) (net.IPNet, error) {
+	for _, pool := range a.pools {
+		ones, bits := pool.Mask.Size()
+		if (bits == 128) != ipv6 {
+			continue
+		}
+		host := net.CIDRMask(bits, bits)
+		taken := append([]net.IPNet{{IP: pool.IP, Mask: host}}, a.reserved...)
+		for _, o := range a.owners {
+			taken = append(taken, o.net)
+		}
+		if bits == 32 && ones < 31 {
+			broadcast := make(net.IP, len(pool.IP))
+			for i := range broadcast {
+				broadcast[i] = pool.IP[i] | ^pool.Mask[i]
+			}
+			taken = append(taken, net.IPNet{IP: broadcast, Mask: host})
+		}
+		free := SubtractCIDRs([]net.IPNet{pool}, taken)
+		if len(free) == 0 {
+			continue
+		}
+		a.owners = append(a.owners, addressOwner{net: net.IPNet{IP: free[0].IP, Mask: host}, peer: a.peers})
+		a.peers++
+		return net.IPNet{IP: free[0].IP, Mask: pool.Mask}, nil
+	}
+	return net.IPNet{}, ErrPoolExhausted
+}
+
+// Allocate returns one free address from each address family the pools have, IPv4 first
+func (a *IPAM) Allocate() ([]net.IPNet, error) {
+	var addrs []net.IPNet
+	for _, ipv6 := range []bool{false, true} {
+		if !a.hasFamily(ipv6) {
+			continue
+		}
+		addr, err := a.Next(ipv6)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no Address to allocate from")
+	}
+	return addrs, nil
+}
+
+// Conflicts returns addresses used by more than one peer or by a peer and the interface, and peer addresses in reserved ranges
+func (a *IPAM) Conflicts() []IPAMConflict {
+	var conflicts []IPAMConflict
+	seen := make(map[int]bool)
+	for i, o := range a.owners {
+		if seen[i] {
+			continue
+		}
+		c := IPAMConflict{Address: o.net, Peers: []int{o.peer}}
+		for j := i + 1; j < len(a.owners); j++ {
+			if other := a.owners[j]; other.peer != o.peer && len(IntersectCIDRs([]net.IPNet{o.net}, []net.IPNet{other.net})) > 0 {
+				c.Peers = append(c.Peers, other.peer)
+				seen[j] = true
+			}
+		}
+		c.Reserved = len(IntersectCIDRs([]net.IPNet{o.net}, a.reserved)) > 0
+		if len(c.Peers) > 1 || c.Reserved {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts
+}
+
+// inPools reports whether the prefix lies within one of the pools, prefixes covering a whole pool don't
+func (a *IPAM) inPools(n net.IPNet) bool {
+	ones, bits := n.Mask.Size()
+	for _, pool := range a.pools {
+		poolOnes, poolBits := pool.Mask.Size()
+		if bits == poolBits && ones > poolOnes && pool.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *IPAM) hasFamily(ipv6 bool) bool {
+	for _, pool := range a.pools {
+		if _, bits := pool.Mask.Size(); (bits == 128) == ipv6 {
+			return true
+		}
+	}
+	return false
+}
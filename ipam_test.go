@@ -0,0 +1,99 @@
+package wgquick
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestIPAMAllocate(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(testConfigs["sample-2"])))
+	a := NewIPAM(c)
+	addrs, err := a.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, "10.192.122.2/24", FormatCIDRs(addrs))
+	addrs, err = a.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, "10.192.122.5/24", FormatCIDRs(addrs), "10.192.122.3 and .4 are used by peers")
+}
+
+func TestIPAMExhaustion(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(`[Interface]
+Address = 10.0.0.1/29
+Address = fd00::1/126
+PrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+`)))
+	a := NewIPAM(c)
+	a.Reserve(mustCIDRs(t, "10.0.0.4/31")...)
+
+	var v4 []net.IPNet
+	for {
+		addr, err := a.Next(false)
+		if err != nil {
+			assert.Equal(t, ErrPoolExhausted, err)
+			break
+		}
+		v4 = append(v4, addr)
+	}
+	assert.Equal(t, "10.0.0.2/29, 10.0.0.3/29, 10.0.0.6/29", FormatCIDRs(v4), "network, broadcast, server and reserved addresses are skipped")
+
+	addr, err := a.Next(true)
+	require.NoError(t, err)
+	assert.Equal(t, "fd00::2/126", addr.String())
+	addr, err = a.Next(true)
+	require.NoError(t, err)
+	assert.Equal(t, "fd00::3/126", addr.String())
+	_, err = a.Next(true)
+	assert.Equal(t, ErrPoolExhausted, err)
+
+	_, err = a.Allocate()
+	assert.Equal(t, ErrPoolExhausted, err)
+}
+
+func TestIPAMMixedPrefixes(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(`[Interface]
+Address = 10.0.0.1/24
+Address = 10.1.0.1/30
+PrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+`)))
+	c.Peers = []wgtypes.PeerConfig{
+		{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "0.0.0.0/0")},
+		{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.0.0.2/31, 10.1.0.2/32, 192.168.0.0/16")},
+		{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.0.0.4/32")},
+	}
+	a := NewIPAM(c)
+	addr, err := a.Next(false)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.5/24", addr.String(), "routed subnet in the pool is used, default route isn't")
+	assert.Empty(t, a.Conflicts())
+
+	_, err = a.Next(true)
+	assert.Equal(t, ErrPoolExhausted, err)
+}
+
+func TestIPAMConflicts(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(`[Interface]
+Address = 10.0.0.1/24
+PrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+`)))
+	c.Peers = []wgtypes.PeerConfig{
+		{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.0.0.1/32")},
+		{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.0.0.2/32")},
+		{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.0.0.0/30")},
+		{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.0.0.200/32")},
+	}
+	a := NewIPAM(c)
+	a.Reserve(mustCIDRs(t, "10.0.0.192/26")...)
+	assert.Equal(t, []IPAMConflict{
+		{Address: mustCIDRs(t, "10.0.0.1/32")[0], Peers: []int{-1, 0, 2}},
+		{Address: mustCIDRs(t, "10.0.0.2/32")[0], Peers: []int{1, 2}},
+		{Address: mustCIDRs(t, "10.0.0.200/32")[0], Peers: []int{3}, Reserved: true},
+	}, a.Conflicts())
+}
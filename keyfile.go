@@ -0,0 +1,92 @@
+package wgquick
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ReadKeyFile reads base64 key from the file, like wg genkey output. Files readable or writable by group or others are refused,
+// the same way ssh refuses private keys with open permissions
+func ReadKeyFile(path string) (wgtypes.Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		return wgtypes.Key{}, fmt.Errorf("key file %s is accessible by group or others (mode %04o), chmod 600 it", path, perm)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+	key, err := wgtypes.ParseKey(strings.TrimSpace(string(b)))
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("key file %s: %v", path, err)
+	}
+	return key, nil
+}
+
+// WriteKeyFile atomically writes the key to the file, readable only by the owner
+func WriteKeyFile(path string, key wgtypes.Key) error {
+	return writeFile(path, []byte(key.String()+"\n"))
+}
+
+// writeKeyFiles writes keys referenced by PrivateKeyFile and PresharedKeyFile, e.g. after rotation. Relative paths are resolved
+// against the config file directory dir, or the directory of the drop-in the peer was read from. Files already holding the key are left untouched
+func writeKeyFiles(cfg *Config, dir string) error {
+	write := func(path string, key *wgtypes.Key) error {
+		if path == "" || key == nil {
+			return nil
+		}
+		if current, err := ReadKeyFile(path); err == nil && current == *key {
+			return nil
+		}
+		return WriteKeyFile(path, *key)
+	}
+	if cfg.PrivateKeyFile != "" {
+		if err := write(resolveKeyFile(dir, cfg.PrivateKeyFile), cfg.PrivateKey); err != nil {
+			return err
+		}
+	}
+	for _, peer := range cfg.Peers {
+		opts, ok := cfg.PeerOptions[peer.PublicKey]
+		if !ok || opts.PresharedKeyFile == "" {
+			continue
+		}
+		peerDir := dir
+		if source, ok := cfg.PeerSources[peer.PublicKey]; ok {
+			peerDir = filepath.Dir(source)
+		}
+		if err := write(resolveKeyFile(peerDir, opts.PresharedKeyFile), peer.PresharedKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configDir returns the directory relative key files of the config file are resolved against.
+// Configs without file resolve them against the working directory
+func configDir(file string) string {
+	if file == "" {
+		return ""
+	}
+	return filepath.Dir(file)
+}
+
+// resolveKeyFile resolves key file path of config in the directory dir
+func resolveKeyFile(dir string, path string) string {
+	if dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
@@ -0,0 +1,147 @@
+package wgquick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestReadKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wg0.key")
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	require.NoError(t, WriteKeyFile(path, key))
+	read, err := ReadKeyFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, key, read)
+
+	require.NoError(t, os.Chmod(path, 0640))
+	_, err = ReadKeyFile(path)
+	assert.EqualError(t, err, "key file "+path+" is accessible by group or others (mode 0640), chmod 600 it")
+
+	require.NoError(t, ioutil.WriteFile(path+"-bad", []byte("not a key\n"), 0600))
+	_, err = ReadKeyFile(path + "-bad")
+	assert.Error(t, err)
+}
+
+func TestKeyFileDirectives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyPath, pskPath := filepath.Join(dir, "wg0.key"), filepath.Join(dir, "peer.psk")
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	psk, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+	require.NoError(t, WriteKeyFile(keyPath, key))
+	require.NoError(t, WriteKeyFile(pskPath, psk))
+
+	text := `[Interface]
+Address = 10.200.100.8/24
+PrivateKeyFile = ` + keyPath + `
+
+[Peer]
+PublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+AllowedIPs = 10.200.100.0/24
+PresharedKeyFile = ` + pskPath + `
+`
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(text)))
+	assert.Equal(t, key, *c.PrivateKey)
+	assert.Equal(t, psk, *c.Peers[0].PresharedKey)
+	assert.Equal(t, text, c.String(), "writes file references back")
+	assert.False(t, strings.Contains(c.String(), key.String()))
+	assert.False(t, strings.Contains(c.String(), psk.String()))
+
+	b, err := c.Encode(FormatJSON)
+	require.NoError(t, err)
+	assert.False(t, strings.Contains(string(b), key.String()))
+	decoded, err := DecodeConfig(FormatJSON, "", b)
+	require.NoError(t, err)
+	assert.Equal(t, text, decoded.String())
+
+	mobile, _, err := c.MobileText()
+	require.NoError(t, err)
+	assert.Contains(t, string(mobile), "PrivateKey = "+key.String())
+	assert.Contains(t, string(mobile), "PresharedKey = "+psk.String())
+
+	// pending keys would end up inline in the config
+	_, err = rotateKeys(c, RotateOptions{PrivateKey: true, Window: time.Hour}, time.Now())
+	assert.EqualError(t, err, "private key read from "+keyPath+", staging it would write the pending key into the config, rotate without window")
+	_, err = rotateKeys(c, RotateOptions{PresharedKeys: true, Window: time.Hour}, time.Now())
+	assert.EqualError(t, err, "peer "+c.Peers[0].PublicKey.String()+": preshared key read from "+pskPath+", staging it would write the pending key into the config, rotate without window")
+	assert.Equal(t, text, c.String(), "untouched")
+
+	// writing the config stores rotated keys into the referenced files
+	r, err := rotateKeys(c, RotateOptions{PrivateKey: true, PresharedKeys: true}, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, WriteConfigFile(filepath.Join(dir, "wg0.conf"), r.Config))
+	rotated, err := ReadConfigFile(filepath.Join(dir, "wg0.conf"))
+	require.NoError(t, err)
+	assert.NotEqual(t, key, *rotated.PrivateKey)
+	assert.Equal(t, *c.PrivateKey, *rotated.PrivateKey)
+	assert.Equal(t, *c.Peers[0].PresharedKey, *rotated.Peers[0].PresharedKey)
+
+	require.NoError(t, os.Chmod(keyPath, 0644))
+	_, err = ReadConfigFile(filepath.Join(dir, "wg0.conf"))
+	assert.Error(t, err)
+
+	err = c.UnmarshalText([]byte("[Interface]\nPrivateKey = " + key.String() + "\nPrivateKeyFile = " + pskPath + "\n"))
+	assert.EqualError(t, err, "3:18: [Interface] PrivateKeyFile: private key already defined")
+}
+
+func TestKeyFileRelativePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wg0.conf")
+	require.NoError(t, os.Mkdir(DropInDir(path), 0700))
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	psk, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+	require.NoError(t, WriteKeyFile(filepath.Join(dir, "wg0.key"), key))
+	require.NoError(t, WriteKeyFile(filepath.Join(DropInDir(path), "alice.psk"), psk))
+	require.NoError(t, ioutil.WriteFile(path, []byte("[Interface]\nAddress = 10.200.100.1/24\nPrivateKeyFile = wg0.key\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(DropInDir(path), "alice.conf"), []byte(`[Peer]
+PublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+AllowedIPs = 10.200.100.2/32
+PresharedKeyFile = alice.psk
+`), 0600))
+
+	// resolved against the config file directory, not the working directory
+	c, err := ReadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, key, *c.PrivateKey)
+	assert.Equal(t, "wg0.key", c.PrivateKeyFile)
+	assert.Equal(t, psk, *c.Peers[0].PresharedKey)
+
+	_, err = rotateKeys(c, RotateOptions{PrivateKey: true, PresharedKeys: true}, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, WriteConfigFile(path, c))
+	rotatedKey, err := ReadKeyFile(filepath.Join(dir, "wg0.key"))
+	require.NoError(t, err)
+	assert.Equal(t, *c.PrivateKey, rotatedKey)
+	rotatedPSK, err := ReadKeyFile(filepath.Join(DropInDir(path), "alice.psk"))
+	require.NoError(t, err)
+	assert.Equal(t, *c.Peers[0].PresharedKey, rotatedPSK)
+
+	yaml := filepath.Join(dir, "wg1.yaml")
+	require.NoError(t, ioutil.WriteFile(yaml, []byte("interface:\n  privateKeyFile: wg0.key\n"), 0600))
+	c, err = ReadConfigFile(yaml)
+	require.NoError(t, err)
+	assert.Equal(t, rotatedKey, *c.PrivateKey)
+}
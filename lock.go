@@ -0,0 +1,159 @@
+package wgquick
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// LockDir holds per interface lock files, <LockDir>/<iface>.lock
+var LockDir = "/run/wg-quick"
+
+// DefaultLockTimeout is used when Config.LockTimeout is not set
+const DefaultLockTimeout = 30 * time.Second
+
+const lockPollInterval = 50 * time.Millisecond
+
+// LockedError is returned when the interface lock couldn't be acquired within the timeout
+type LockedError struct {
+	Iface string
+	// Holder describes the lock owner, e.g. "pid 1234 (wg-quick sync wg0), sync wg0 since 2019-01-02T15:04:05Z, called from main.main".
+	// When the owner is this very process, the pid is left out and only the operation, its start and the caller are given
+	Holder string
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("interface %s is locked by %s", e.Iface, e.Holder)
+}
+
+// InterfaceLock is exclusive lock over single interface, both across goroutines and across processes
+type InterfaceLock struct {
+	iface string
+	file  *os.File
+	local *localLock
+}
+
+type localLock struct {
+	ch     chan struct{}
+	holder string
+}
+
+var (
+	localLocksMu sync.Mutex
+	localLocks   = make(map[string]*localLock)
+)
+
+func getLocalLock(iface string) *localLock {
+	localLocksMu.Lock()
+	defer localLocksMu.Unlock()
+	l, ok := localLocks[iface]
+	if !ok {
+		l = &localLock{ch: make(chan struct{}, 1)}
+		localLocks[iface] = l
+	}
+	return l
+}
+
+// LockInterface acquires the lock for the given interface, waiting at most timeout. Release it with Unlock.
+// Up, Down and Sync hold this lock while they run
+func LockInterface(iface string, timeout time.Duration) (*InterfaceLock, error) {
+	return lockInterface(iface, "lock", timeout)
+}
+
+// lockInterface acquires the interface lock for op, e.g. "sync". The operation, its start and the function which
+// called the operation describe the holder in LockedError
+func lockInterface(iface string, op string, timeout time.Duration) (*InterfaceLock, error) {
+	now := time.Now()
+	deadline := now.Add(timeout)
+	operation := fmt.Sprintf("%s %s since %s, called from %s", op, iface, now.Format(time.RFC3339), callerName(2))
+	holder := fmt.Sprintf("pid %d (%s), %s", os.Getpid(), strings.Join(os.Args, " "), operation)
+
+	local := getLocalLock(iface)
+	select {
+	case local.ch <- struct{}{}:
+	case <-time.After(timeout):
+		localLocksMu.Lock()
+		defer localLocksMu.Unlock()
+		return nil, &LockedError{Iface: iface, Holder: local.holder}
+	}
+	localLocksMu.Lock()
+	local.holder = operation
+	localLocksMu.Unlock()
+
+	f, err := lockFile(iface, deadline)
+	if err != nil {
+		<-local.ch
+		return nil, err
+	}
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(holder+"\n"), 0)
+	}
+	return &InterfaceLock{iface: iface, file: f, local: local}, nil
+}
+
+// callerName returns the package qualified name of the function skip frames above the caller of callerName, e.g. "wgquick.(*Manager).SyncAll"
+func callerName(skip int) string {
+	pc := make([]uintptr, 1)
+	if runtime.Callers(skip+2, pc) == 0 {
+		return "unknown"
+	}
+	frame, _ := runtime.CallersFrames(pc).Next()
+	if frame.Function == "" {
+		return "unknown"
+	}
+	return frame.Function[strings.LastIndex(frame.Function, "/")+1:]
+}
+
+func lockFile(iface string, deadline time.Time) (*os.File, error) {
+	if err := os.MkdirAll(LockDir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(LockDir, iface+".lock")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			return f, nil
+		}
+		if err != unix.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("cannot lock %s: %v", path, err)
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			holder := "unknown process"
+			if b, err := ioutil.ReadFile(path); err == nil && len(strings.TrimSpace(string(b))) > 0 {
+				holder = strings.TrimSpace(string(b))
+			}
+			return nil, &LockedError{Iface: iface, Holder: holder}
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Unlock releases the interface lock
+func (l *InterfaceLock) Unlock() error {
+	err := unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	<-l.local.ch
+	return err
+}
+
+func lockTimeout(cfg *Config) time.Duration {
+	if cfg.LockTimeout > 0 {
+		return cfg.LockTimeout
+	}
+	return DefaultLockTimeout
+}
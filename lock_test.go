@@ -0,0 +1,62 @@
+package wgquick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockInterface(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick-lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(old string) { LockDir = old }(LockDir)
+	LockDir = dir
+
+	lock, err := LockInterface("wg0", time.Second)
+	require.NoError(t, err)
+
+	_, err = LockInterface("wg0", 100*time.Millisecond)
+	if assert.IsType(t, &LockedError{}, err) {
+		assert.Contains(t, err.Error(), "wg0")
+	}
+
+	other, err := LockInterface("wg1", time.Second)
+	require.NoError(t, err)
+	assert.NoError(t, other.Unlock())
+
+	assert.NoError(t, lock.Unlock())
+	lock, err = LockInterface("wg0", time.Second)
+	require.NoError(t, err)
+	assert.NoError(t, lock.Unlock())
+}
+
+func TestLockInterfaceHolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick-lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(old string) { LockDir = old }(LockDir)
+	LockDir = dir
+
+	lock, err := lockInterface("wg0", "sync", time.Second)
+	require.NoError(t, err)
+	defer lock.Unlock()
+
+	// held inside this process, the holder is the operation and who called it, not the process itself
+	_, err = LockInterface("wg0", 100*time.Millisecond)
+	if assert.IsType(t, &LockedError{}, err) {
+		holder := err.(*LockedError).Holder
+		assert.Regexp(t, `^sync wg0 since \S+, called from testing\.tRunner$`, holder)
+		assert.NotContains(t, holder, "pid")
+	}
+
+	// other processes read the lock file
+	b, err := ioutil.ReadFile(filepath.Join(dir, "wg0.lock"))
+	require.NoError(t, err)
+	assert.Regexp(t, `^pid \d+ \(.*\), sync wg0 since \S+, called from testing\.tRunner\n$`, string(b))
+}
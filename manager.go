@@ -0,0 +1,414 @@
+package wgquick
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// DefaultConfigDir is where wg-quick looks for interface configs
+const DefaultConfigDir = "/etc/wireguard"
+
+// InterfaceErrors collects per interface errors from the Manager operations
+type InterfaceErrors map[string]error
+
+func (e InterfaceErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %v", name, e[name])
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Manager manages lifecycle of many wireguard interfaces, one per config file in the Dir. The interface name is the config file name without .conf suffix
+type Manager struct {
+	// Dir is the directory holding <iface>.conf files
+	Dir string
+
+	// Concurrency limits how many interfaces are brought up/down/synced at the same time. Values < 1 are treated as 1
+	Concurrency int
+
+	// Dependencies lists for each interface the interfaces which must be up before it, e.g. when the endpoint is reachable only through another tunnel
+	Dependencies map[string][]string
+
+	log logrus.FieldLogger
+}
+
+// NewManager creates manager for the configs in the given directory
+func NewManager(dir string, logger logrus.FieldLogger) *Manager {
+	return &Manager{
+		Dir:          dir,
+		Concurrency:  4,
+		Dependencies: make(map[string][]string),
+		log:          logger.WithField("dir", dir),
+	}
+}
+
+// ParseDependencies parses comma separated iface:dependency pairs into Manager.Dependencies, e.g. "wg1:wg0,wg2:wg0,wg2:wg1"
+func ParseDependencies(s string) (map[string][]string, error) {
+	deps := make(map[string][]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid dependency %q, expected iface:dependency", pair)
+		}
+		deps[parts[0]] = append(deps[parts[0]], parts[1])
+	}
+	return deps, nil
+}
+
+// Load reads all configs from the managed directory, keyed by the interface name. Configs which can't be read are
+// left out and reported in the returned InterfaceErrors, the rest is still returned
+func (m *Manager) Load() (map[string]*Config, error) {
+	paths, err := filepath.Glob(filepath.Join(m.Dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	cfgs := make(map[string]*Config, len(paths))
+	errs := InterfaceErrors{}
+	for _, path := range paths {
+		iface := strings.TrimSuffix(filepath.Base(path), ".conf")
+		cfg, err := ReadConfigFile(path)
+		if err != nil {
+			m.log.WithError(err).WithField("iface", iface).Error("cannot read config, skipping")
+			errs[iface] = err
+			continue
+		}
+		cfgs[iface] = cfg
+	}
+	if len(errs) > 0 {
+		return cfgs, errs
+	}
+	return cfgs, nil
+}
+
+// load is Load, which only fails for other reasons than unreadable configs
+func (m *Manager) load() (map[string]*Config, InterfaceErrors, error) {
+	cfgs, err := m.Load()
+	if err == nil {
+		return cfgs, InterfaceErrors{}, nil
+	}
+	errs, ok := err.(InterfaceErrors)
+	if !ok {
+		return nil, nil, err
+	}
+	return cfgs, errs, nil
+}
+
+// UpAll brings up all interfaces from the managed directory, dependencies first. Interfaces which are already up are synced instead
+func (m *Manager) UpAll() error {
+	cfgs, errs, err := m.load()
+	if err != nil {
+		return err
+	}
+	return m.run(cfgs, errs, false, func(iface string, cfg *Config, log logrus.FieldLogger) error {
+		err := Up(cfg, iface, log)
+		if err == os.ErrExist {
+			log.Info("interface already up, syncing")
+			err = Sync(cfg, iface, log)
+		}
+		if err != nil {
+			return err
+		}
+		return m.markApplied(iface, cfg)
+	})
+}
+
+// DownAll brings down all interfaces from the managed directory, dependents first. Interfaces which are already down are skipped
+func (m *Manager) DownAll() error {
+	cfgs, errs, err := m.load()
+	if err != nil {
+		return err
+	}
+	return m.run(cfgs, errs, true, func(iface string, cfg *Config, log logrus.FieldLogger) error {
+		err := Down(cfg, iface, log)
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			log.Info("interface already down")
+			return RemoveState(iface)
+		}
+		return err
+	})
+}
+
+// SyncAll reconciles the managed directory with the system state. Interfaces which are down are brought up, interfaces whose
+// config changed since the last apply by a manager of this directory are synced, and interfaces such manager applied whose config file was deleted are brought down.
+// Applied configs are recorded in the interface State, so they're tracked across processes
+func (m *Manager) SyncAll() error {
+	cfgs, loadErrs, err := m.load()
+	if err != nil {
+		return err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	removed := make(map[string]*Config)
+	for iface, cfg := range applied {
+		_, unreadable := loadErrs[iface]
+		if _, ok := cfgs[iface]; !ok && !unreadable {
+			removed[iface] = cfg
+		}
+	}
+
+	errs := InterfaceErrors{}
+	if err := m.run(removed, nil, true, func(iface string, cfg *Config, log logrus.FieldLogger) error {
+		log.Info("config file removed, bringing interface down")
+		err := Down(cfg, iface, log)
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return RemoveState(iface)
+		}
+		return err
+	}); err != nil {
+		ifaceErrs, ok := err.(InterfaceErrors)
+		if !ok {
+			return err
+		}
+		for iface, err := range ifaceErrs {
+			errs[iface] = err
+		}
+	}
+
+	if err := m.run(cfgs, loadErrs, false, func(iface string, cfg *Config, log logrus.FieldLogger) error {
+		_, err := netlink.LinkByName(iface)
+		switch err.(type) {
+		case nil:
+			if !m.changed(iface, cfg) {
+				log.Debug("config unchanged, skipping")
+				return nil
+			}
+			err = Sync(cfg, iface, log)
+		case netlink.LinkNotFoundError:
+			// Up, not Sync, so DNS and the PreUp/PostUp hooks run
+			log.Info("interface down, bringing up")
+			err = Up(cfg, iface, log)
+		}
+		if err != nil {
+			return err
+		}
+		return m.markApplied(iface, cfg)
+	}); err != nil {
+		ifaceErrs, ok := err.(InterfaceErrors)
+		if !ok {
+			return err
+		}
+		for iface, err := range ifaceErrs {
+			errs[iface] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// applied returns the interfaces applied from the managed directory according to their State,
+// with configs holding only what Down needs
+func (m *Manager) applied() (map[string]*Config, error) {
+	paths, err := filepath.Glob(filepath.Join(StateDir, "*.state"))
+	if err != nil {
+		return nil, err
+	}
+	cfgs := make(map[string]*Config)
+	for _, path := range paths {
+		iface := strings.TrimSuffix(filepath.Base(path), ".state")
+		st, err := LoadState(iface)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if st.Applied == nil || st.Applied.Dir != m.Dir {
+			continue
+		}
+		cfg := &Config{PreDown: st.Applied.PreDown, PostDown: st.Applied.PostDown}
+		for _, dns := range st.Applied.DNS {
+			cfg.DNS = append(cfg.DNS, net.ParseIP(dns))
+		}
+		cfgs[iface] = cfg
+	}
+	return cfgs, nil
+}
+
+func (m *Manager) changed(iface string, cfg *Config) bool {
+	st, err := LoadState(iface)
+	if err != nil || st.Applied == nil {
+		return true
+	}
+	hash, err := configHash(cfg)
+	if err != nil {
+		return true
+	}
+	return st.Applied.Dir != m.Dir || st.Applied.Hash != hash
+}
+
+// markApplied records the config in the interface State
+func (m *Manager) markApplied(iface string, cfg *Config) error {
+	hash, err := configHash(cfg)
+	if err != nil {
+		return err
+	}
+	lock, err := lockInterface(iface, "record applied config", lockTimeout(cfg))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	st, err := LoadState(iface)
+	if err != nil {
+		return err
+	}
+	st.Applied = &AppliedConfig{Dir: m.Dir, Hash: hash, PreDown: cfg.PreDown, PostDown: cfg.PostDown}
+	for _, dns := range cfg.DNS {
+		st.Applied.DNS = append(st.Applied.DNS, dns.String())
+	}
+	return st.Save(iface)
+}
+
+// configHash hashes the config text together with the keys, which the text may only reference by file
+func configHash(cfg *Config) (string, error) {
+	text, err := cfg.MarshalText()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(text)
+	if cfg.PrivateKey != nil {
+		h.Write(cfg.PrivateKey[:])
+	}
+	for _, peer := range cfg.Peers {
+		if peer.PresharedKey != nil {
+			h.Write(peer.PresharedKey[:])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// run executes op over the configs in dependency order (reversed if requested), at most Concurrency at the same time.
+// If an interface fails, or is already in failed, interfaces depending on it are skipped. Returned error, if any, is InterfaceErrors
+// including the failed ones
+func (m *Manager) run(cfgs map[string]*Config, failed InterfaceErrors, reverse bool, op func(iface string, cfg *Config, log logrus.FieldLogger) error) error {
+	levels, err := m.order(cfgs)
+	if err != nil {
+		return err
+	}
+	deps := m.Dependencies
+	if reverse {
+		for i, j := 0, len(levels)-1; i < j; i, j = i+1, j-1 {
+			levels[i], levels[j] = levels[j], levels[i]
+		}
+		deps = make(map[string][]string)
+		for iface, ifaceDeps := range m.Dependencies {
+			for _, dep := range ifaceDeps {
+				deps[dep] = append(deps[dep], iface)
+			}
+		}
+	}
+
+	concurrency := m.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	errs := InterfaceErrors{}
+	for iface, err := range failed {
+		errs[iface] = err
+	}
+	var mu sync.Mutex
+
+	for _, level := range levels {
+		wg := sync.WaitGroup{}
+		for _, iface := range level {
+			log := m.log.WithField("iface", iface)
+
+			mu.Lock()
+			var failedDep string
+			for _, dep := range deps[iface] {
+				if _, failed := errs[dep]; failed {
+					failedDep = dep
+				}
+			}
+			if failedDep != "" {
+				errs[iface] = fmt.Errorf("dependency %s failed", failedDep)
+				mu.Unlock()
+				log.WithField("dependency", failedDep).Error("skipping, dependency failed")
+				continue
+			}
+			mu.Unlock()
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(iface string, log logrus.FieldLogger) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if err := op(iface, cfgs[iface], log); err != nil {
+					log.WithError(err).Error("operation failed")
+					mu.Lock()
+					errs[iface] = err
+					mu.Unlock()
+				}
+			}(iface, log)
+		}
+		wg.Wait()
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// order groups interfaces into levels such that every interface comes after all its dependencies. Dependencies outside of cfgs are ignored
+func (m *Manager) order(cfgs map[string]*Config) ([][]string, error) {
+	pending := make(map[string]bool, len(cfgs))
+	for iface := range cfgs {
+		pending[iface] = true
+	}
+
+	var levels [][]string
+	for len(pending) > 0 {
+		var level []string
+		for iface := range pending {
+			ready := true
+			for _, dep := range m.Dependencies[iface] {
+				if pending[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				level = append(level, iface)
+			}
+		}
+		if len(level) == 0 {
+			var cycle []string
+			for iface := range pending {
+				cycle = append(cycle, iface)
+			}
+			sort.Strings(cycle)
+			return nil, fmt.Errorf("dependency cycle between interfaces %v", cycle)
+		}
+		sort.Strings(level)
+		for _, iface := range level {
+			delete(pending, iface)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
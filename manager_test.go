@@ -0,0 +1,123 @@
+package wgquick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestManagerOrder(t *testing.T) {
+	m := NewManager("/nonexistent", logrus.New())
+	cfgs := map[string]*Config{"wg0": {}, "wg1": {}, "wg2": {}, "wg3": {}}
+	m.Dependencies["wg1"] = []string{"wg0"}
+	m.Dependencies["wg2"] = []string{"wg1", "wg-missing"}
+
+	levels, err := m.order(cfgs)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"wg0", "wg3"}, {"wg1"}, {"wg2"}}, levels)
+
+	m.Dependencies["wg0"] = []string{"wg2"}
+	_, err = m.order(cfgs)
+	assert.Error(t, err)
+}
+
+func TestParseDependencies(t *testing.T) {
+	deps, err := ParseDependencies("wg1:wg0, wg2:wg0,wg2:wg1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"wg1": {"wg0"}, "wg2": {"wg0", "wg1"}}, deps)
+	deps, err = ParseDependencies("")
+	require.NoError(t, err)
+	assert.Empty(t, deps)
+	_, err = ParseDependencies("wg1")
+	assert.EqualError(t, err, `invalid dependency "wg1", expected iface:dependency`)
+}
+
+func TestManagerLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick-manager")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "wg0.conf"), []byte(testConfigs["simple"]), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "wg1.conf"), []byte(testConfigs["sample-2"]), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a config"), 0600))
+
+	cfgs, err := NewManager(dir, logrus.New()).Load()
+	require.NoError(t, err)
+	require.Len(t, cfgs, 2)
+	assert.Equal(t, testConfigs["simple"], cfgs["wg0"].String())
+	assert.Equal(t, testConfigs["sample-2"], cfgs["wg1"].String())
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "wg2.conf"), []byte("[Interface]\nBogus = 1\n"), 0600))
+	m := NewManager(dir, logrus.New())
+	cfgs, err = m.Load()
+	require.IsType(t, InterfaceErrors{}, err)
+	assert.Contains(t, err.(InterfaceErrors), "wg2")
+	assert.Len(t, err.(InterfaceErrors), 1)
+	require.Len(t, cfgs, 2, "valid configs still loaded")
+
+	// interfaces depending on the unreadable one are skipped, the rest goes on
+	m.Dependencies["wg1"] = []string{"wg2"}
+	cfgs, loadErrs, err := m.load()
+	require.NoError(t, err)
+	var ran []string
+	err = m.run(cfgs, loadErrs, false, func(iface string, cfg *Config, log logrus.FieldLogger) error {
+		ran = append(ran, iface)
+		return nil
+	})
+	assert.Equal(t, []string{"wg0"}, ran)
+	require.IsType(t, InterfaceErrors{}, err)
+	assert.EqualError(t, err.(InterfaceErrors)["wg1"], "dependency wg2 failed")
+	assert.Contains(t, err.(InterfaceErrors), "wg2")
+}
+
+func TestManagerApplied(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "wg-quick-state")
+	require.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+	defer func(old string) { StateDir = old }(StateDir)
+	defer func(old string) { LockDir = old }(LockDir)
+	StateDir = stateDir
+	LockDir = stateDir
+
+	cfg := &Config{}
+	require.NoError(t, cfg.UnmarshalText([]byte(testConfigs["simple"])))
+	cfg.PreDown = "echo down"
+	m := NewManager("/etc/wireguard", logrus.New())
+	assert.True(t, m.changed("wg0", cfg), "never applied")
+
+	// sync state of the interface survives
+	require.NoError(t, (&State{Addresses: []string{"10.0.0.1/24"}}).Save("wg0"))
+	require.NoError(t, m.markApplied("wg0", cfg))
+	st, err := LoadState("wg0")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1/24"}, st.Addresses)
+
+	// a new manager, e.g. the next sync-all run, sees what the previous one applied
+	m = NewManager("/etc/wireguard", logrus.New())
+	assert.False(t, m.changed("wg0", cfg))
+	assert.True(t, NewManager("/srv/wireguard", logrus.New()).changed("wg0", cfg), "applied from another directory")
+	changed := &Config{}
+	require.NoError(t, changed.UnmarshalText([]byte(testConfigs["simple"])))
+	changed.Peers[0].AllowedIPs = mustCIDRs(t, "10.0.0.0/8")
+	assert.True(t, m.changed("wg0", changed))
+
+	// config text only references the key file
+	fileBacked := *cfg
+	fileBacked.PrivateKeyFile = "wg2.key"
+	require.NoError(t, m.markApplied("wg2", &fileBacked))
+	assert.False(t, m.changed("wg2", &fileBacked))
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	fileBacked.PrivateKey = &key
+	assert.True(t, m.changed("wg2", &fileBacked), "key file content counts")
+
+	require.NoError(t, NewManager("/srv/wireguard", logrus.New()).markApplied("wg1", cfg))
+	applied, err := m.applied()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*Config{"wg0": {DNS: cfg.DNS, PreDown: "echo down"}, "wg2": {DNS: cfg.DNS, PreDown: "echo down"}}, applied, "only interfaces applied from the managed directory")
+}
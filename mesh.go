@@ -0,0 +1,48 @@
+package wgquick
+
+import (
+	"fmt"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// FullMesh generates config for every inventory node, having all the other nodes as peers. keys are private keys by node name,
+// see LoadOrCreateKeys. Nodes without public endpoint keep their NAT mappings open with PersistentKeepalive.
+// Two nodes without public endpoint can't reach each other, they're still peers in case one of them roams to public address
+func (inv *Inventory) FullMesh(keys map[string]wgtypes.Key) (map[string]*Config, error) {
+	peers := make([]wgtypes.PeerConfig, len(inv.Nodes))
+	for i := range inv.Nodes {
+		node := &inv.Nodes[i]
+		key, ok := keys[node.Name]
+		if !ok {
+			return nil, fmt.Errorf("node %s: missing key", node.Name)
+		}
+		peer, err := inv.peerConfig(node, key)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %v", node.Name, err)
+		}
+		peers[i] = peer
+	}
+
+	configs := make(map[string]*Config, len(inv.Nodes))
+	for i := range inv.Nodes {
+		node := &inv.Nodes[i]
+		cfg, err := inv.interfaceConfig(node, keys[node.Name])
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %v", node.Name, err)
+		}
+		for j, peer := range peers {
+			if j == i {
+				continue
+			}
+			if node.Endpoint == "" {
+				keepalive := time.Duration(inv.keepalive()) * time.Second
+				peer.PersistentKeepaliveInterval = &keepalive
+			}
+			cfg.Peers = append(cfg.Peers, peer)
+		}
+		configs[node.Name] = cfg
+	}
+	return configs, nil
+}
@@ -0,0 +1,121 @@
+package wgquick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testInventory = `listenPort: 51821
+nodes:
+- name: fra
+  endpoint: 127.0.0.1
+  address: 10.10.0.1/24
+  subnets: [192.168.1.0/24]
+- name: ams
+  endpoint: 127.0.0.2:4500
+  address: 10.10.0.2/24
+- name: laptop
+  address: 10.10.0.3/24
+`
+
+func writeInventory(t *testing.T, dir string, text string) *Inventory {
+	path := filepath.Join(dir, "inventory.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(text), 0600))
+	inv, err := ReadInventory(path)
+	require.NoError(t, err)
+	return inv
+}
+
+func TestFullMesh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	inv := writeInventory(t, dir, testInventory)
+	keys, err := inv.LoadOrCreateKeys(filepath.Join(dir, "keys"))
+	require.NoError(t, err)
+	configs, err := inv.FullMesh(keys)
+	require.NoError(t, err)
+	require.Len(t, configs, 3)
+
+	pub := func(name string) string {
+		return keys[name].PublicKey().String()
+	}
+	assert.Equal(t, `[Interface]
+Address = 10.10.0.1/24
+PrivateKey = `+keys["fra"].String()+`
+ListenPort = 51821
+
+[Peer]
+PublicKey = `+pub("ams")+`
+AllowedIPs = 10.10.0.2/32
+Endpoint = 127.0.0.2:4500
+
+[Peer]
+PublicKey = `+pub("laptop")+`
+AllowedIPs = 10.10.0.3/32
+`, configs["fra"].String())
+	assert.Equal(t, `[Interface]
+Address = 10.10.0.3/24
+PrivateKey = `+keys["laptop"].String()+`
+
+[Peer]
+PublicKey = `+pub("fra")+`
+AllowedIPs = 10.10.0.1/32, 192.168.1.0/24
+PersistentKeepalive = 25
+Endpoint = 127.0.0.1:51821
+
+[Peer]
+PublicKey = `+pub("ams")+`
+AllowedIPs = 10.10.0.2/32
+PersistentKeepalive = 25
+Endpoint = 127.0.0.2:4500
+`, configs["laptop"].String())
+	for name, cfg := range configs {
+		assert.NoError(t, cfg.Validate().Err(), name)
+	}
+
+	// adding a node keeps the existing keys
+	inv = writeInventory(t, dir, testInventory+"- name: nyc\n  endpoint: 127.0.0.3\n  address: 10.10.0.4/24\n")
+	newKeys, err := inv.LoadOrCreateKeys(filepath.Join(dir, "keys"))
+	require.NoError(t, err)
+	assert.Len(t, newKeys, 4)
+	for name, key := range keys {
+		assert.Equal(t, key, newKeys[name], name)
+	}
+	configs, err = inv.FullMesh(newKeys)
+	require.NoError(t, err)
+	assert.Len(t, configs["fra"].Peers, 3)
+}
+
+func TestReadInventoryErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "inventory.yaml")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("nodes:\n- name: a\n  address: 10.0.0.1/24\n- name: a\n  address: 10.0.0.2/24\n"), 0600))
+	_, err = ReadInventory(path)
+	assert.EqualError(t, err, path+": node a: duplicate name")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("nodes:\n- name: a\n  address: 10.0.0.1\n"), 0600))
+	_, err = ReadInventory(path)
+	assert.EqualError(t, err, path+": node a: invalid CIDR address: 10.0.0.1")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("nodes:\n- name: a\n  address: 10.0.0.1/24\n- name: b\n  address: 10.0.0.1/24\n"), 0600))
+	_, err = ReadInventory(path)
+	assert.EqualError(t, err, path+": node b: address 10.0.0.1 already used by node a")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("nodes:\n- name: a\n  address: 10.0.0.1/24\n  subnets: [192.168.1.0/24]\n- name: b\n  address: 10.0.0.2/24\n  subnets: [192.168.1.0/24]\n"), 0600))
+	_, err = ReadInventory(path)
+	assert.EqualError(t, err, path+": node b: subnet 192.168.1.0/24 already routed to node a")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("nodes:\n- name: a\n  address: 10.0.0.1/24\n  subnets: [192.168.1.0/24, 192.168.2.0/24]\n- name: b\n  address: 10.0.0.2/24\n  subnets: [192.168.0.0/16]\n"), 0600))
+	_, err = ReadInventory(path)
+	assert.EqualError(t, err, path+": node b: subnets overlap with subnets of node a on 192.168.1.0/24, 192.168.2.0/24")
+}
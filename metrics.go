@@ -0,0 +1,155 @@
+package wgquick
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DeviceLister lists wireguard devices. *wgctrl.Client satisfies it
+type DeviceLister interface {
+	Devices() ([]*wgtypes.Device, error)
+}
+
+// Collector is prometheus collector exporting per peer statistics of all wireguard devices
+type Collector struct {
+	client DeviceLister
+	now    func() time.Time
+
+	receiveBytes   *prometheus.Desc
+	transmitBytes  *prometheus.Desc
+	lastHandshake  *prometheus.Desc
+	endpointInfo   *prometheus.Desc
+	allowedIPs     *prometheus.Desc
+	scrapeFailures prometheus.Counter
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector creates the collector reading devices from the client
+func NewCollector(client DeviceLister) *Collector {
+	peerLabels := []string{"interface", "public_key"}
+	return &Collector{
+		client: client,
+		now:    time.Now,
+		receiveBytes: prometheus.NewDesc(
+			"wireguard_peer_receive_bytes_total",
+			"Bytes received from the peer",
+			peerLabels, nil),
+		transmitBytes: prometheus.NewDesc(
+			"wireguard_peer_transmit_bytes_total",
+			"Bytes transmitted to the peer",
+			peerLabels, nil),
+		lastHandshake: prometheus.NewDesc(
+			"wireguard_peer_last_handshake_age_seconds",
+			"Seconds since the last handshake with the peer. Absent if there was no handshake",
+			peerLabels, nil),
+		endpointInfo: prometheus.NewDesc(
+			"wireguard_peer_endpoint_info",
+			"Current peer endpoint, always 1",
+			append(peerLabels, "endpoint"), nil),
+		allowedIPs: prometheus.NewDesc(
+			"wireguard_peer_allowed_ips",
+			"Number of AllowedIPs prefixes of the peer",
+			peerLabels, nil),
+		scrapeFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wireguard_scrape_failures_total",
+			Help: "Failures to read wireguard devices",
+		}),
+	}
+}
+
+// SyncCollector is prometheus collector counting Sync operations in this process, register it with RegisterSyncObserver.
+// The counts aren't persisted, so it's only useful in long running processes embedding the library, e.g. config agents,
+// not in the wg-quick metrics command
+type SyncCollector struct {
+	syncs            *prometheus.CounterVec
+	syncErrors       *prometheus.CounterVec
+	driftCorrections *prometheus.CounterVec
+}
+
+var _ prometheus.Collector = (*SyncCollector)(nil)
+var _ SyncObserver = (*SyncCollector)(nil)
+
+// NewSyncCollector creates the collector of Sync counters
+func NewSyncCollector() *SyncCollector {
+	return &SyncCollector{
+		syncs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wgquick_sync_total",
+			Help: "Sync operations",
+		}, []string{"interface"}),
+		syncErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wgquick_sync_errors_total",
+			Help: "Failed sync operations",
+		}, []string{"interface"}),
+		driftCorrections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wgquick_sync_drift_corrections_total",
+			Help: "Peers Sync added, updated or removed on the device because they differed from the config",
+		}, []string{"interface", "change"}),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.receiveBytes
+	ch <- c.transmitBytes
+	ch <- c.lastHandshake
+	ch <- c.endpointInfo
+	ch <- c.allowedIPs
+	c.scrapeFailures.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	devices, err := c.client.Devices()
+	if err != nil {
+		c.scrapeFailures.Inc()
+		c.scrapeFailures.Collect(ch)
+		return
+	}
+	c.scrapeFailures.Collect(ch)
+
+	now := c.now()
+	for _, dev := range devices {
+		for _, peer := range dev.Peers {
+			key := peer.PublicKey.String()
+			ch <- prometheus.MustNewConstMetric(c.receiveBytes, prometheus.CounterValue, float64(peer.ReceiveBytes), dev.Name, key)
+			ch <- prometheus.MustNewConstMetric(c.transmitBytes, prometheus.CounterValue, float64(peer.TransmitBytes), dev.Name, key)
+			ch <- prometheus.MustNewConstMetric(c.allowedIPs, prometheus.GaugeValue, float64(len(peer.AllowedIPs)), dev.Name, key)
+			if !peer.LastHandshakeTime.IsZero() {
+				ch <- prometheus.MustNewConstMetric(c.lastHandshake, prometheus.GaugeValue, now.Sub(peer.LastHandshakeTime).Seconds(), dev.Name, key)
+			}
+			if peer.Endpoint != nil {
+				ch <- prometheus.MustNewConstMetric(c.endpointInfo, prometheus.GaugeValue, 1, dev.Name, key, peer.Endpoint.String())
+			}
+		}
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *SyncCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.syncs.Describe(ch)
+	c.syncErrors.Describe(ch)
+	c.driftCorrections.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *SyncCollector) Collect(ch chan<- prometheus.Metric) {
+	c.syncs.Collect(ch)
+	c.syncErrors.Collect(ch)
+	c.driftCorrections.Collect(ch)
+}
+
+// ObserveSync implements SyncObserver
+func (c *SyncCollector) ObserveSync(iface string, changes *DeviceChanges, err error) {
+	c.syncs.WithLabelValues(iface).Inc()
+	if err != nil {
+		c.syncErrors.WithLabelValues(iface).Inc()
+	}
+	if changes != nil {
+		c.driftCorrections.WithLabelValues(iface, "added").Add(float64(len(changes.Added)))
+		c.driftCorrections.WithLabelValues(iface, "updated").Add(float64(len(changes.Updated)))
+		c.driftCorrections.WithLabelValues(iface, "removed").Add(float64(len(changes.Removed)))
+	}
+}
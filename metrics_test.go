@@ -0,0 +1,90 @@
+package wgquick
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type fakeDevices []*wgtypes.Device
+
+func (f fakeDevices) Devices() ([]*wgtypes.Device, error) {
+	return f, nil
+}
+
+func TestCollector(t *testing.T) {
+	peerKey, err := ParseKey("GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=")
+	require.NoError(t, err)
+	idleKey, err := ParseKey("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=")
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+
+	c := NewCollector(fakeDevices{{
+		Name: "wg0",
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:         peerKey,
+				Endpoint:          &net.UDPAddr{IP: net.ParseIP("123.12.12.1"), Port: 51820},
+				LastHandshakeTime: now.Add(-90 * time.Second),
+				ReceiveBytes:      100,
+				TransmitBytes:     200,
+				AllowedIPs:        mustCIDRs(t, "10.0.0.0/24, 10.1.0.0/24"),
+			},
+			{PublicKey: idleKey},
+		},
+	}})
+	c.now = func() time.Time { return now }
+
+	expected := `
+# HELP wireguard_peer_allowed_ips Number of AllowedIPs prefixes of the peer
+# TYPE wireguard_peer_allowed_ips gauge
+wireguard_peer_allowed_ips{interface="wg0",public_key="GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU="} 2
+wireguard_peer_allowed_ips{interface="wg0",public_key="xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="} 0
+# HELP wireguard_peer_endpoint_info Current peer endpoint, always 1
+# TYPE wireguard_peer_endpoint_info gauge
+wireguard_peer_endpoint_info{endpoint="123.12.12.1:51820",interface="wg0",public_key="GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU="} 1
+# HELP wireguard_peer_last_handshake_age_seconds Seconds since the last handshake with the peer. Absent if there was no handshake
+# TYPE wireguard_peer_last_handshake_age_seconds gauge
+wireguard_peer_last_handshake_age_seconds{interface="wg0",public_key="GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU="} 90
+# HELP wireguard_peer_receive_bytes_total Bytes received from the peer
+# TYPE wireguard_peer_receive_bytes_total counter
+wireguard_peer_receive_bytes_total{interface="wg0",public_key="GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU="} 100
+wireguard_peer_receive_bytes_total{interface="wg0",public_key="xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"wireguard_peer_allowed_ips",
+		"wireguard_peer_endpoint_info",
+		"wireguard_peer_last_handshake_age_seconds",
+		"wireguard_peer_receive_bytes_total",
+	))
+}
+
+func TestSyncCollector(t *testing.T) {
+	idleKey, err := ParseKey("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=")
+	require.NoError(t, err)
+	c := NewSyncCollector()
+	c.ObserveSync("wg0", &DeviceChanges{Removed: []wgtypes.Key{idleKey}}, nil)
+	c.ObserveSync("wg0", nil, errors.New("boom"))
+
+	expected := `
+# HELP wgquick_sync_drift_corrections_total Peers Sync added, updated or removed on the device because they differed from the config
+# TYPE wgquick_sync_drift_corrections_total counter
+wgquick_sync_drift_corrections_total{change="added",interface="wg0"} 0
+wgquick_sync_drift_corrections_total{change="removed",interface="wg0"} 1
+wgquick_sync_drift_corrections_total{change="updated",interface="wg0"} 0
+# HELP wgquick_sync_errors_total Failed sync operations
+# TYPE wgquick_sync_errors_total counter
+wgquick_sync_errors_total{interface="wg0"} 1
+# HELP wgquick_sync_total Sync operations
+# TYPE wgquick_sync_total counter
+wgquick_sync_total{interface="wg0"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+}
@@ -0,0 +1,311 @@
+package wgquick
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// NetworkdUnits are systemd-networkd .netdev and .network files describing single wireguard interface
+type NetworkdUnits struct {
+	// Name of the interface, [NetDev] Name and [Match] Name. Filled in by FromNetworkd
+	Name string
+	// NetDev is the .netdev file with [WireGuard] and [WireGuardPeer] sections
+	NetDev []byte
+	// Network is the .network file with addresses, DNS and routes
+	Network []byte
+}
+
+// ToNetworkd converts the config to systemd-networkd units. Routes for all AllowedIPs are added in [Route] sections, like Up does.
+// Returned warnings list settings without networkd equivalent, which are dropped
+func (cfg *Config) ToNetworkd(iface string) (*NetworkdUnits, []string, error) {
+	if cfg.PrivateKey == nil {
+		return nil, nil, fmt.Errorf("missing private key")
+	}
+	// the exported config has no ExcludedIPs, it gets the expanded AllowedIPs
+	cfg = cfg.withExcludedIPs()
+	var warnings []string
+	warnf := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	netdev := &bytes.Buffer{}
+	fmt.Fprintf(netdev, "[NetDev]\nName=%s\nKind=wireguard\n", iface)
+	if cfg.MTU > 0 {
+		fmt.Fprintf(netdev, "MTUBytes=%d\n", cfg.MTU)
+	}
+	if cfg.PrivateKeyFile != "" {
+		fmt.Fprintf(netdev, "\n[WireGuard]\nPrivateKeyFile=%s\n", resolveKeyFile(cfg.dir, cfg.PrivateKeyFile))
+		warnf("PrivateKeyFile has to be readable by the systemd-network group, which ReadKeyFile refuses")
+	} else {
+		fmt.Fprintf(netdev, "\n[WireGuard]\nPrivateKey=%s\n", cfg.PrivateKey)
+	}
+	if cfg.ListenPort != nil {
+		fmt.Fprintf(netdev, "ListenPort=%d\n", *cfg.ListenPort)
+	}
+	if cfg.FirewallMark != nil && *cfg.FirewallMark != 0 {
+		fmt.Fprintf(netdev, "FirewallMark=%d\n", *cfg.FirewallMark)
+	}
+	for _, peer := range cfg.Peers {
+		fmt.Fprintf(netdev, "\n[WireGuardPeer]\nPublicKey=%s\n", peer.PublicKey)
+		if opts, ok := cfg.PeerOptions[peer.PublicKey]; ok && opts.PresharedKeyFile != "" {
+			fmt.Fprintf(netdev, "PresharedKeyFile=%s\n", resolveKeyFile(cfg.dir, opts.PresharedKeyFile))
+			warnf("peer %s: PresharedKeyFile has to be readable by the systemd-network group, which ReadKeyFile refuses", peer.PublicKey)
+		} else if peer.PresharedKey != nil {
+			fmt.Fprintf(netdev, "PresharedKey=%s\n", peer.PresharedKey)
+		}
+		if len(peer.AllowedIPs) > 0 {
+			fmt.Fprintf(netdev, "AllowedIPs=%s\n", FormatCIDRs(peer.AllowedIPs))
+		}
+		if peer.Endpoint != nil {
+			fmt.Fprintf(netdev, "Endpoint=%s\n", peer.Endpoint)
+		}
+		if peer.PersistentKeepaliveInterval != nil && *peer.PersistentKeepaliveInterval > 0 {
+			fmt.Fprintf(netdev, "PersistentKeepalive=%d\n", toSeconds(*peer.PersistentKeepaliveInterval))
+		}
+		if opts, ok := cfg.PeerOptions[peer.PublicKey]; ok {
+			if len(opts.FallbackEndpoints) > 0 {
+				warnf("peer %s: FallbackEndpoint has no networkd equivalent, dropped", peer.PublicKey)
+			}
+			if opts.HealthCheck != nil {
+				warnf("peer %s: HealthCheck has no networkd equivalent, dropped", peer.PublicKey)
+			}
+			if opts.PendingPublicKey != nil || opts.PendingPresharedKey != nil {
+				warnf("peer %s: PendingPublicKey and PendingPresharedKey have no networkd equivalent, dropped", peer.PublicKey)
+			}
+		}
+	}
+
+	network := &bytes.Buffer{}
+	fmt.Fprintf(network, "[Match]\nName=%s\n\n[Network]\n", iface)
+	for _, addr := range cfg.Address {
+		fmt.Fprintf(network, "Address=%s\n", addr.String())
+	}
+	if len(cfg.DNS) > 0 {
+		dns := make([]string, len(cfg.DNS))
+		for i, ip := range cfg.DNS {
+			dns[i] = ip.String()
+		}
+		fmt.Fprintf(network, "DNS=%s\n", strings.Join(dns, " "))
+	}
+	if cfg.Forwarding {
+		fmt.Fprintf(network, "IPForward=yes\n")
+	}
+	for _, peer := range cfg.Peers {
+		metric := 0
+		if opts, ok := cfg.PeerOptions[peer.PublicKey]; ok {
+			metric = opts.RouteMetric
+		}
+		for _, dst := range peer.AllowedIPs {
+			dst = net.IPNet{IP: dst.IP.Mask(dst.Mask), Mask: dst.Mask}
+			fmt.Fprintf(network, "\n[Route]\nDestination=%s\n", dst.String())
+			if cfg.Table != 0 {
+				fmt.Fprintf(network, "Table=%d\n", cfg.Table)
+			}
+			if metric != 0 {
+				fmt.Fprintf(network, "Metric=%d\n", metric)
+			}
+		}
+	}
+
+	for _, hook := range []directive{{"PreUp", cfg.PreUp}, {"PostUp", cfg.PostUp}, {"PreDown", cfg.PreDown}, {"PostDown", cfg.PostDown}} {
+		if hook.value != "" {
+			warnf("%s has no networkd equivalent, dropped", hook.key)
+		}
+	}
+	if cfg.PendingPrivateKey != nil {
+		warnf("PendingPrivateKey has no networkd equivalent, dropped")
+	}
+	if cfg.SaveConfig {
+		warnf("SaveConfig has no networkd equivalent, dropped")
+	}
+	return &NetworkdUnits{Name: iface, NetDev: netdev.Bytes(), Network: network.Bytes()}, warnings, nil
+}
+
+// FromNetworkd parses systemd-networkd units back into the config. Network may be empty.
+// Returned warnings list settings without wg-quick equivalent, which are ignored
+func FromNetworkd(units *NetworkdUnits) (*Config, []string, error) {
+	cfg := &Config{}
+	var warnings []string
+	warnf := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	sections, err := parseUnit(units.NetDev)
+	if err != nil {
+		return nil, nil, fmt.Errorf("netdev: %v", err)
+	}
+	for _, s := range sections {
+		var peerCfg *wgtypes.PeerConfig
+		peerOpts := &PeerOptions{}
+		if s.name == "WireGuardPeer" {
+			cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{})
+			peerCfg = &cfg.Peers[len(cfg.Peers)-1]
+		}
+		for _, kv := range s.keys {
+			var err error
+			switch s.name + "." + kv.key {
+			case "NetDev.Name":
+				units.Name = kv.value
+			case "NetDev.Kind":
+				if kv.value != "wireguard" {
+					return nil, nil, fmt.Errorf("netdev: kind %s isn't wireguard", kv.value)
+				}
+			case "NetDev.MTUBytes":
+				err = parseInterfaceLine(cfg, "MTU", kv.value)
+			case "WireGuard.PrivateKey", "WireGuard.PrivateKeyFile", "WireGuard.ListenPort":
+				if kv.value == "auto" {
+					continue
+				}
+				err = parseInterfaceLine(cfg, kv.key, kv.value)
+			case "WireGuard.FirewallMark":
+				err = parseInterfaceLine(cfg, "FwMark", kv.value)
+			case "WireGuardPeer.PublicKey", "WireGuardPeer.PresharedKey", "WireGuardPeer.PresharedKeyFile", "WireGuardPeer.AllowedIPs", "WireGuardPeer.Endpoint", "WireGuardPeer.PersistentKeepalive":
+				if kv.key == "PersistentKeepalive" && kv.value == "off" {
+					continue
+				}
+				err = parsePeerLine(cfg, peerCfg, peerOpts, kv.key, kv.value)
+			default:
+				warnf("netdev: [%s] %s has no wg-quick equivalent, ignored", s.name, kv.key)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("netdev: [%s] %s: %v", s.name, kv.key, err)
+			}
+		}
+		if peerCfg != nil && !peerOpts.empty() {
+			*cfg.Options(peerCfg.PublicKey) = *peerOpts
+		}
+	}
+
+	sections, err = parseUnit(units.Network)
+	if err != nil {
+		return nil, nil, fmt.Errorf("network: %v", err)
+	}
+	var routes []net.IPNet
+	metrics := make(map[string]int)
+	for _, s := range sections {
+		var sectionRoutes []net.IPNet
+		metric := 0
+		for _, kv := range s.keys {
+			var err error
+			switch s.name + "." + kv.key {
+			case "Match.Name":
+			case "Network.IPForward":
+				cfg.Forwarding = kv.value != "no" && kv.value != "false"
+			case "Network.Address":
+				err = parseInterfaceLine(cfg, "Address", kv.value)
+			case "Network.DNS":
+				err = parseInterfaceLine(cfg, "DNS", strings.Join(strings.Fields(kv.value), ","))
+			case "Route.Destination":
+				var dst []net.IPNet
+				if dst, err = ParseCIDRs(kv.value); err == nil {
+					sectionRoutes = append(sectionRoutes, dst...)
+				}
+			case "Route.Table":
+				var table int64
+				if table, err = strconv.ParseInt(kv.value, 10, 32); err == nil {
+					if cfg.Table != 0 && cfg.Table != int(table) {
+						warnf("network: routes use different tables, using %d", cfg.Table)
+						continue
+					}
+					cfg.Table = int(table)
+				}
+			case "Route.Metric":
+				var m int64
+				if m, err = strconv.ParseInt(kv.value, 10, 32); err == nil {
+					metric = int(m)
+				}
+			default:
+				warnf("network: [%s] %s has no wg-quick equivalent, ignored", s.name, kv.key)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("network: [%s] %s: %v", s.name, kv.key, err)
+			}
+		}
+		routes = append(routes, sectionRoutes...)
+		for _, dst := range sectionRoutes {
+			if metric != 0 {
+				metrics[dst.String()] = metric
+			}
+		}
+	}
+	// route metrics become RouteMetric of the peer whose AllowedIPs they route
+	for _, peer := range cfg.Peers {
+		for _, allowed := range peer.AllowedIPs {
+			dst := net.IPNet{IP: allowed.IP.Mask(allowed.Mask), Mask: allowed.Mask}
+			if metric, ok := metrics[dst.String()]; ok {
+				cfg.Options(peer.PublicKey).RouteMetric = metric
+			}
+		}
+	}
+
+	// wg-quick routes exactly the AllowedIPs, so routes outside of them are lost and AllowedIPs without route gain one
+	var allowed []net.IPNet
+	for _, peer := range cfg.Peers {
+		allowed = append(allowed, peer.AllowedIPs...)
+	}
+	if extra := SubtractCIDRs(routes, allowed); len(extra) > 0 {
+		warnf("network: routes %s aren't in any peer AllowedIPs, ignored", FormatCIDRs(extra))
+	}
+	if len(sections) > 0 {
+		if missing := SubtractCIDRs(allowed, routes); len(missing) > 0 {
+			warnf("network: AllowedIPs %s have no route, wg-quick routes them", FormatCIDRs(missing))
+		}
+	}
+	return cfg, warnings, nil
+}
+
+// ReadNetworkdFiles reads the .netdev file and the .network file next to it with the same name, if present
+func ReadNetworkdFiles(netdevPath string) (*Config, []string, error) {
+	units := &NetworkdUnits{}
+	var err error
+	if units.NetDev, err = ioutil.ReadFile(netdevPath); err != nil {
+		return nil, nil, err
+	}
+	networkPath := strings.TrimSuffix(netdevPath, ".netdev") + ".network"
+	if units.Network, err = ioutil.ReadFile(networkPath); err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	return FromNetworkd(units)
+}
+
+type unitSection struct {
+	name string
+	keys []unitKey
+}
+
+type unitKey struct {
+	key, value string
+}
+
+// parseUnit parses systemd unit file into sections in file order, sections like [WireGuardPeer] may repeat
+func parseUnit(text []byte) ([]unitSection, error) {
+	var sections []unitSection
+	sc := bufio.NewScanner(bytes.NewReader(text))
+	for no := 1; sc.Scan(); no++ {
+		ln := strings.TrimSpace(sc.Text())
+		switch {
+		case ln == "" || ln[0] == '#' || ln[0] == ';':
+		case ln[0] == '[' && ln[len(ln)-1] == ']':
+			sections = append(sections, unitSection{name: ln[1 : len(ln)-1]})
+		default:
+			eq := strings.Index(ln, "=")
+			if eq < 0 {
+				return nil, fmt.Errorf("line %d: missing =", no)
+			}
+			if len(sections) == 0 {
+				return nil, fmt.Errorf("line %d: key outside of section", no)
+			}
+			s := &sections[len(sections)-1]
+			s.keys = append(s.keys, unitKey{key: strings.TrimSpace(ln[:eq]), value: strings.TrimSpace(ln[eq+1:])})
+		}
+	}
+	return sections, sc.Err()
+}
@@ -0,0 +1,119 @@
+package wgquick
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkdRoundTrip(t *testing.T) {
+	for name, text := range testConfigs {
+		t.Run(name, func(t *testing.T) {
+			c := &Config{}
+			require.NoError(t, c.UnmarshalText([]byte(text)))
+			units, exportWarnings, err := c.ToNetworkd("wg0")
+			require.NoError(t, err)
+			decoded, importWarnings, err := FromNetworkd(&NetworkdUnits{NetDev: units.NetDev, Network: units.Network})
+			require.NoError(t, err)
+			assert.Empty(t, importWarnings)
+			if len(exportWarnings) == 0 {
+				assert.Equal(t, text, decoded.String())
+			}
+		})
+	}
+}
+
+func TestToNetworkd(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(testConfigs["simple"])))
+	c.Table = 1234
+	c.PostUp = "iptables -A FORWARD -i %i -j ACCEPT"
+	units, warnings, err := c.ToNetworkd("wg0")
+	require.NoError(t, err)
+	assert.Equal(t, `[NetDev]
+Name=wg0
+Kind=wireguard
+
+[WireGuard]
+PrivateKey=oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+
+[WireGuardPeer]
+PublicKey=GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+PresharedKey=/UwcSPg38hW/D9Y3tcS1FOV0K1wuURMbS0sesJEP5ak=
+AllowedIPs=0.0.0.0/0
+Endpoint=123.12.12.1:51820
+`, string(units.NetDev))
+	assert.Equal(t, `[Match]
+Name=wg0
+
+[Network]
+Address=10.200.100.8/24
+DNS=10.200.100.1
+
+[Route]
+Destination=0.0.0.0/0
+Table=1234
+`, string(units.Network))
+	assert.Equal(t, []string{"PostUp has no networkd equivalent, dropped"}, warnings)
+}
+
+func TestFromNetworkd(t *testing.T) {
+	units := &NetworkdUnits{
+		NetDev: []byte(`[NetDev]
+Name=wg1
+Kind=wireguard
+MTUBytes=1400
+
+[WireGuard]
+PrivateKey=oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+ListenPort=auto
+FirewallMark=42
+
+[WireGuardPeer]
+PublicKey=GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+AllowedIPs=10.0.0.0/24
+AllowedIPs=10.1.0.0/24
+PersistentKeepalive=25
+`),
+		Network: []byte(`[Match]
+Name=wg1
+
+[Network]
+Address=10.0.0.2/24
+DNS=10.0.0.1 10.0.0.53
+
+[Route]
+Destination=10.0.0.0/24
+Table=100
+
+[Route]
+Destination=192.168.0.0/16
+Table=100
+`),
+	}
+	c, warnings, err := FromNetworkd(units)
+	require.NoError(t, err)
+	assert.Equal(t, "wg1", units.Name)
+	assert.Equal(t, `[Interface]
+Address = 10.0.0.2/24
+DNS = 10.0.0.1
+DNS = 10.0.0.53
+PrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+FwMark = 42
+MTU = 1400
+Table = 100
+
+[Peer]
+PublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+AllowedIPs = 10.0.0.0/24, 10.1.0.0/24
+PersistentKeepalive = 25
+`, c.String())
+	assert.Equal(t, []string{
+		"network: routes 192.168.0.0/16 aren't in any peer AllowedIPs, ignored",
+		"network: AllowedIPs 10.1.0.0/24 have no route, wg-quick routes them",
+	}, warnings)
+
+	_, _, err = FromNetworkd(&NetworkdUnits{NetDev: []byte("[NetDev]\nKind=vxlan\n")})
+	assert.EqualError(t, err, "netdev: kind vxlan isn't wireguard")
+}
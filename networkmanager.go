@@ -0,0 +1,248 @@
+package wgquick
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ToNetworkManager converts the config to NetworkManager keyfile connection, the .nmconnection file of type wireguard.
+// Connection UUID is derived from the interface name and the private key, so exporting the same config again gives the same connection.
+// Returned warnings list settings without NetworkManager equivalent, which are dropped
+func (cfg *Config) ToNetworkManager(iface string) ([]byte, []string, error) {
+	if cfg.PrivateKey == nil {
+		return nil, nil, fmt.Errorf("missing private key")
+	}
+	// the exported config has no ExcludedIPs, it gets the expanded AllowedIPs
+	cfg = cfg.withExcludedIPs()
+	var warnings []string
+	warnf := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "[connection]\nid=%s\nuuid=%s\ntype=wireguard\ninterface-name=%s\n", iface, connectionUUID(iface, cfg.PrivateKey), iface)
+
+	fmt.Fprintf(b, "\n[wireguard]\nprivate-key=%s\n", cfg.PrivateKey)
+	if cfg.ListenPort != nil {
+		fmt.Fprintf(b, "listen-port=%d\n", *cfg.ListenPort)
+	}
+	if cfg.MTU > 0 {
+		fmt.Fprintf(b, "mtu=%d\n", cfg.MTU)
+	}
+	if cfg.FirewallMark != nil {
+		fmt.Fprintf(b, "fwmark=%d\n", *cfg.FirewallMark)
+	}
+
+	for _, peer := range cfg.Peers {
+		fmt.Fprintf(b, "\n[wireguard-peer.%s]\n", peer.PublicKey)
+		if peer.Endpoint != nil {
+			fmt.Fprintf(b, "endpoint=%s\n", peer.Endpoint)
+		}
+		if peer.PresharedKey != nil {
+			fmt.Fprintf(b, "preshared-key=%s\npreshared-key-flags=0\n", peer.PresharedKey)
+		}
+		if peer.PersistentKeepaliveInterval != nil && *peer.PersistentKeepaliveInterval > 0 {
+			fmt.Fprintf(b, "persistent-keepalive=%d\n", toSeconds(*peer.PersistentKeepaliveInterval))
+		}
+		allowed := make([]string, len(peer.AllowedIPs))
+		for i, n := range peer.AllowedIPs {
+			allowed[i] = n.String()
+		}
+		fmt.Fprintf(b, "allowed-ips=%s\n", nmList(allowed))
+		if opts, ok := cfg.PeerOptions[peer.PublicKey]; ok {
+			if len(opts.FallbackEndpoints) > 0 {
+				warnf("peer %s: FallbackEndpoint has no NetworkManager equivalent, dropped", peer.PublicKey)
+			}
+			if opts.HealthCheck != nil {
+				warnf("peer %s: HealthCheck has no NetworkManager equivalent, dropped", peer.PublicKey)
+			}
+			if opts.PresharedKeyFile != "" {
+				warnf("peer %s: PresharedKeyFile has no NetworkManager equivalent, key inlined", peer.PublicKey)
+			}
+			if opts.PendingPublicKey != nil || opts.PendingPresharedKey != nil {
+				warnf("peer %s: PendingPublicKey and PendingPresharedKey have no NetworkManager equivalent, dropped", peer.PublicKey)
+			}
+			if opts.RouteMetric != 0 {
+				warnf("peer %s: RouteMetric has no NetworkManager equivalent, dropped", peer.PublicKey)
+			}
+		}
+	}
+
+	for _, family := range []struct {
+		section string
+		v4      bool
+	}{{"ipv4", true}, {"ipv6", false}} {
+		var addrs, dns []string
+		for _, addr := range cfg.Address {
+			if (addr.IP.To4() != nil) == family.v4 {
+				addrs = append(addrs, addr.String())
+			}
+		}
+		for _, ip := range cfg.DNS {
+			if (ip.To4() != nil) == family.v4 {
+				dns = append(dns, ip.String())
+			}
+		}
+
+		fmt.Fprintf(b, "\n[%s]\n", family.section)
+		for i, addr := range addrs {
+			fmt.Fprintf(b, "address%d=%s\n", i+1, addr)
+		}
+		if len(dns) > 0 {
+			fmt.Fprintf(b, "dns=%s\n", nmList(dns))
+		}
+		switch {
+		case len(addrs) > 0:
+			fmt.Fprintf(b, "method=manual\n")
+		case family.v4:
+			fmt.Fprintf(b, "method=disabled\n")
+		default:
+			fmt.Fprintf(b, "method=ignore\n")
+		}
+		if cfg.Table != 0 {
+			fmt.Fprintf(b, "route-table=%d\n", cfg.Table)
+		}
+	}
+
+	for _, hook := range []directive{{"PreUp", cfg.PreUp}, {"PostUp", cfg.PostUp}, {"PreDown", cfg.PreDown}, {"PostDown", cfg.PostDown}} {
+		if hook.value != "" {
+			warnf("%s has no NetworkManager equivalent, dropped", hook.key)
+		}
+	}
+	if cfg.Forwarding {
+		warnf("Forwarding has no NetworkManager equivalent, dropped")
+	}
+	if cfg.PrivateKeyFile != "" {
+		warnf("PrivateKeyFile has no NetworkManager equivalent, key inlined")
+	}
+	if cfg.PendingPrivateKey != nil {
+		warnf("PendingPrivateKey has no NetworkManager equivalent, dropped")
+	}
+	if cfg.SaveConfig {
+		warnf("SaveConfig has no NetworkManager equivalent, dropped")
+	}
+	return b.Bytes(), warnings, nil
+}
+
+// FromNetworkManager parses NetworkManager wireguard keyfile connection, returning the config and the interface name.
+// Returned warnings list settings without wg-quick equivalent, which are ignored
+func FromNetworkManager(text []byte) (*Config, string, []string, error) {
+	cfg := &Config{}
+	var iface string
+	var warnings []string
+	warnf := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	sections, err := parseUnit(text)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	for _, s := range sections {
+		var peerCfg *wgtypes.PeerConfig
+		if strings.HasPrefix(s.name, "wireguard-peer.") {
+			key, err := ParseKey(strings.TrimPrefix(s.name, "wireguard-peer."))
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("[%s]: cannot decode key %v", s.name, err)
+			}
+			cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{PublicKey: key})
+			peerCfg = &cfg.Peers[len(cfg.Peers)-1]
+		}
+
+		for _, kv := range s.keys {
+			var err error
+			section := s.name
+			if peerCfg != nil {
+				section = "wireguard-peer"
+			}
+			switch section + "." + strings.TrimRightFunc(kv.key, isDigit) {
+			case "connection.id", "connection.uuid", "ipv4.method", "ipv6.method", "ipv6.addr-gen-mode", "wireguard-peer.preshared-key-flags":
+			case "connection.type":
+				if kv.value != "wireguard" {
+					return nil, "", nil, fmt.Errorf("connection type %s isn't wireguard", kv.value)
+				}
+			case "connection.interface-name":
+				iface = kv.value
+			case "wireguard.private-key":
+				err = parseInterfaceLine(cfg, "PrivateKey", kv.value)
+			case "wireguard.listen-port":
+				err = parseInterfaceLine(cfg, "ListenPort", kv.value)
+			case "wireguard.mtu":
+				err = parseInterfaceLine(cfg, "MTU", kv.value)
+			case "wireguard.fwmark":
+				err = parseInterfaceLine(cfg, "FwMark", kv.value)
+			case "wireguard-peer.endpoint":
+				err = parsePeerLine(cfg, peerCfg, &PeerOptions{}, "Endpoint", kv.value)
+			case "wireguard-peer.preshared-key":
+				err = parsePeerLine(cfg, peerCfg, &PeerOptions{}, "PresharedKey", kv.value)
+			case "wireguard-peer.persistent-keepalive":
+				err = parsePeerLine(cfg, peerCfg, &PeerOptions{}, "PersistentKeepalive", kv.value)
+			case "wireguard-peer.allowed-ips":
+				if list := nmSplit(kv.value); len(list) > 0 {
+					err = parsePeerLine(cfg, peerCfg, &PeerOptions{}, "AllowedIPs", strings.Join(list, ","))
+				}
+			case "ipv4.address", "ipv6.address":
+				// addressN=ip/prefix[,gateway]
+				addr := strings.SplitN(kv.value, ",", 2)
+				if len(addr) == 2 {
+					warnf("[%s] %s gateway has no wg-quick equivalent, ignored", s.name, kv.key)
+				}
+				err = parseInterfaceLine(cfg, "Address", addr[0])
+			case "ipv4.dns", "ipv6.dns":
+				if list := nmSplit(kv.value); len(list) > 0 {
+					err = parseInterfaceLine(cfg, "DNS", strings.Join(list, ","))
+				}
+			case "ipv4.route-table", "ipv6.route-table":
+				var table int64
+				if table, err = strconv.ParseInt(kv.value, 10, 32); err == nil && table != 0 {
+					if cfg.Table != 0 && cfg.Table != int(table) {
+						warnf("[%s] route-table %d differs from %d, using %d", s.name, table, cfg.Table, cfg.Table)
+						continue
+					}
+					cfg.Table = int(table)
+				}
+			default:
+				warnf("[%s] %s has no wg-quick equivalent, ignored", s.name, kv.key)
+			}
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("[%s] %s: %v", s.name, kv.key, err)
+			}
+		}
+	}
+	return cfg, iface, warnings, nil
+}
+
+// connectionUUID derives random looking (version 4 layout) UUID from the interface and its key
+func connectionUUID(iface string, key *wgtypes.Key) string {
+	h := sha256.Sum256(append([]byte(iface+"\x00"), key[:]...))
+	h[6] = h[6]&0x0f | 0x40
+	h[8] = h[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// nmList formats keyfile list, each element terminated with ;
+func nmList(items []string) string {
+	if len(items) == 0 {
+		return ""
+	}
+	return strings.Join(items, ";") + ";"
+}
+
+func nmSplit(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
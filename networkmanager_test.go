@@ -0,0 +1,91 @@
+package wgquick
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+func TestToNetworkManager(t *testing.T) {
+	for name, text := range testConfigs {
+		t.Run(name, func(t *testing.T) {
+			c := &Config{}
+			require.NoError(t, c.UnmarshalText([]byte(text)))
+			b, _, err := c.ToNetworkManager("wg0")
+			require.NoError(t, err)
+
+			golden := filepath.Join("testdata", "networkmanager", name+".nmconnection")
+			if *update {
+				require.NoError(t, ioutil.WriteFile(golden, b, 0644))
+			}
+			expected, err := ioutil.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), string(b))
+		})
+	}
+}
+
+func TestFromNetworkManager(t *testing.T) {
+	for name, text := range testConfigs {
+		t.Run(name, func(t *testing.T) {
+			c := &Config{}
+			require.NoError(t, c.UnmarshalText([]byte(text)))
+			_, exportWarnings, err := c.ToNetworkManager("wg0")
+			require.NoError(t, err)
+
+			b, err := ioutil.ReadFile(filepath.Join("testdata", "networkmanager", name+".nmconnection"))
+			require.NoError(t, err)
+			decoded, iface, warnings, err := FromNetworkManager(b)
+			require.NoError(t, err)
+			assert.Equal(t, "wg0", iface)
+			assert.Empty(t, warnings)
+			if len(exportWarnings) == 0 {
+				assert.Equal(t, text, decoded.String())
+			}
+		})
+	}
+}
+
+func TestFromNetworkManagerWarnings(t *testing.T) {
+	c, iface, warnings, err := FromNetworkManager([]byte(`[connection]
+id=office
+type=wireguard
+interface-name=wg1
+autoconnect=false
+
+[wireguard]
+private-key=oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+
+[wireguard-peer.GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=]
+allowed-ips=10.0.0.0/8;
+
+[ipv4]
+address1=10.1.0.2/16,10.1.0.1
+dns=10.1.0.1;
+method=manual
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "wg1", iface)
+	assert.Equal(t, `[Interface]
+Address = 10.1.0.2/16
+DNS = 10.1.0.1
+PrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+
+[Peer]
+PublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+AllowedIPs = 10.0.0.0/8
+`, c.String())
+	assert.Equal(t, []string{
+		"[connection] autoconnect has no wg-quick equivalent, ignored",
+		"[ipv4] address1 gateway has no wg-quick equivalent, ignored",
+	}, warnings)
+
+	_, _, _, err = FromNetworkManager([]byte("[connection]\ntype=vpn\n"))
+	assert.EqualError(t, err, "connection type vpn isn't wireguard")
+}
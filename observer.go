@@ -0,0 +1,41 @@
+package wgquick
+
+import "sync"
+
+// SyncObserver is notified after every Sync, e.g. to export metrics or emit events.
+// changes is nil if Sync failed before the wireguard device was synced
+type SyncObserver interface {
+	ObserveSync(iface string, changes *DeviceChanges, err error)
+}
+
+var (
+	observersMu sync.RWMutex
+	observers   []SyncObserver
+)
+
+// RegisterSyncObserver registers observer notified after every Sync in this process
+func RegisterSyncObserver(o SyncObserver) {
+	observersMu.Lock()
+	defer observersMu.Unlock()
+	observers = append(observers, o)
+}
+
+// UnregisterSyncObserver removes previously registered observer
+func UnregisterSyncObserver(o SyncObserver) {
+	observersMu.Lock()
+	defer observersMu.Unlock()
+	for i, registered := range observers {
+		if registered == o {
+			observers = append(observers[:i:i], observers[i+1:]...)
+			return
+		}
+	}
+}
+
+func notifySync(iface string, changes *DeviceChanges, err error) {
+	observersMu.RLock()
+	defer observersMu.RUnlock()
+	for _, o := range observers {
+		o.ObserveSync(iface, changes, err)
+	}
+}
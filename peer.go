@@ -0,0 +1,266 @@
+package wgquick
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// AddPeer adds the peer to the config and to the live interface, together with routes for its AllowedIPs.
+// Other peers, addresses and routes are left untouched. Unless path is empty, the config is written there too, under the same interface lock
+func AddPeer(path string, cfg *Config, iface string, peer wgtypes.PeerConfig, logger logrus.FieldLogger) error {
+	peer.Remove = false
+	peer.UpdateOnly = false
+	peer.ReplaceAllowedIPs = true
+	peers, err := withPeer(cfg.Peers, peer, false)
+	if err != nil {
+		return err
+	}
+	next := *cfg
+	next.Peers = peers
+	return changePeer(path, cfg, &next, iface, peer, nil, logger)
+}
+
+// UpdatePeer replaces the peer with the same public key in the config and on the live interface, adding and removing its routes as needed.
+// Routes of AllowedIPs another peer still has are kept. A nil PresharedKey or PersistentKeepaliveInterval clears it on the interface.
+// Unless path is empty, the config is written there too, under the same interface lock
+func UpdatePeer(path string, cfg *Config, iface string, peer wgtypes.PeerConfig, logger logrus.FieldLogger) error {
+	peer.Remove = false
+	peer.UpdateOnly = true
+	peer.ReplaceAllowedIPs = true
+	peers, err := withPeer(cfg.Peers, peer, true)
+	if err != nil {
+		return err
+	}
+	next := *cfg
+	next.Peers = peers
+	return changePeer(path, cfg, &next, iface, peer, staleAllowedIPs(cfg, peers, cfg.Peers[findPeer(cfg, peer.PublicKey)]), logger)
+}
+
+// RemovePeer removes the peer and its options from the config and from the live interface, together with routes for its AllowedIPs
+// no other peer has. Unless path is empty, the config is written there too, under the same interface lock
+func RemovePeer(path string, cfg *Config, iface string, key wgtypes.Key, logger logrus.FieldLogger) error {
+	next, err := cfg.withoutPeer(key)
+	if err != nil {
+		return err
+	}
+	peer := wgtypes.PeerConfig{PublicKey: key, Remove: true}
+	return changePeer(path, cfg, next, iface, peer, staleAllowedIPs(cfg, next.Peers, cfg.Peers[findPeer(cfg, key)]), logger)
+}
+
+// changePeer validates next, the config with the peer changed, applies the peer to the live interface and writes next to path
+// if set, all under the interface lock. cfg becomes next once that succeeds
+func changePeer(path string, cfg, next *Config, iface string, peer wgtypes.PeerConfig, staleAllowedIPs []net.IPNet, logger logrus.FieldLogger) error {
+	log := logger.WithFields(map[string]interface{}{
+		"iface": iface,
+		"peer":  peer.PublicKey.String(),
+	})
+	if err := validate(next, log); err != nil {
+		return err
+	}
+	op := "add-peer"
+	switch {
+	case peer.Remove:
+		op = "remove-peer"
+	case peer.UpdateOnly:
+		op = "update-peer"
+	}
+	lock, err := lockInterface(iface, op, lockTimeout(cfg))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if err := applyPeer(cfg, next, iface, peer, staleAllowedIPs, log); err != nil {
+		return err
+	}
+	if path != "" {
+		if err := WriteConfigFile(path, next); err != nil {
+			log.WithError(err).Error("cannot write config")
+			return err
+		}
+		log.WithField("path", path).Info("config written")
+	}
+	// the source of a removed peer stays until the write above, so its drop-in file gets truncated
+	if peer.Remove {
+		delete(next.PeerSources, peer.PublicKey)
+	}
+	*cfg = *next
+	return nil
+}
+
+func findPeer(cfg *Config, key wgtypes.Key) int {
+	return peerIndex(cfg.Peers, key)
+}
+
+func peerIndex(peers []wgtypes.PeerConfig, key wgtypes.Key) int {
+	for i, peer := range peers {
+		if peer.PublicKey == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// withPeer returns copy of peers with the peer appended, or replacing the peer with the same public key on update
+func withPeer(peers []wgtypes.PeerConfig, peer wgtypes.PeerConfig, update bool) ([]wgtypes.PeerConfig, error) {
+	idx := peerIndex(peers, peer.PublicKey)
+	switch {
+	case update && idx < 0:
+		return nil, fmt.Errorf("peer %s not found", peer.PublicKey)
+	case !update && idx >= 0:
+		return nil, fmt.Errorf("peer %s already exists", peer.PublicKey)
+	}
+	updated := append([]wgtypes.PeerConfig(nil), peers...)
+	if update {
+		updated[idx] = peer
+		return updated, nil
+	}
+	return append(updated, peer), nil
+}
+
+// withoutPeer returns copy of peers without the peer with the given public key
+func withoutPeer(peers []wgtypes.PeerConfig, key wgtypes.Key) ([]wgtypes.PeerConfig, error) {
+	idx := peerIndex(peers, key)
+	if idx < 0 {
+		return nil, fmt.Errorf("peer %s not found", key)
+	}
+	return append(peers[:idx:idx], peers[idx+1:]...), nil
+}
+
+// withoutPeer returns copy of the config without the peer with the given public key and its PeerOptions.
+// PeerSources is copied as is, changePeer drops the peer source once the config is written
+func (cfg *Config) withoutPeer(key wgtypes.Key) (*Config, error) {
+	peers, err := withoutPeer(cfg.Peers, key)
+	if err != nil {
+		return nil, err
+	}
+	next := *cfg
+	next.Peers = peers
+	if cfg.PeerOptions != nil {
+		next.PeerOptions = make(map[wgtypes.Key]*PeerOptions, len(cfg.PeerOptions))
+		for k, opts := range cfg.PeerOptions {
+			if k != key {
+				next.PeerOptions[k] = opts
+			}
+		}
+	}
+	if cfg.PeerSources != nil {
+		next.PeerSources = make(map[wgtypes.Key]string, len(cfg.PeerSources))
+		for k, source := range cfg.PeerSources {
+			next.PeerSources[k] = source
+		}
+	}
+	return &next, nil
+}
+
+// staleAllowedIPs returns the old AllowedIPs of a changed peer none of the peers allows anymore, their routes can go.
+// ExcludedIPs of cfg are subtracted from both
+func staleAllowedIPs(cfg *Config, peers []wgtypes.PeerConfig, old wgtypes.PeerConfig) []net.IPNet {
+	allowed := make(map[string]bool)
+	for _, peer := range peers {
+		for _, dst := range cfg.allowedIPs(peer) {
+			allowed[(&net.IPNet{IP: dst.IP.Mask(dst.Mask), Mask: dst.Mask}).String()] = true
+		}
+	}
+	var stale []net.IPNet
+	for _, dst := range cfg.allowedIPs(old) {
+		if !allowed[(&net.IPNet{IP: dst.IP.Mask(dst.Mask), Mask: dst.Mask}).String()] {
+			stale = append(stale, dst)
+		}
+	}
+	return stale
+}
+
+// applyPeer configures single peer on the device, adds routes for the peer AllowedIPs of next and deletes the ones of cfg for staleAllowedIPs.
+// The caller holds the interface lock
+func applyPeer(cfg, next *Config, iface string, peer wgtypes.PeerConfig, staleAllowedIPs []net.IPNet, log logrus.FieldLogger) error {
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		log.WithError(err).Error("cannot read link")
+		return err
+	}
+	st, err := LoadState(iface)
+	if err != nil {
+		log.WithError(err).Error("cannot read interface state")
+		return err
+	}
+
+	cl, err := wgctrl.New()
+	if err != nil {
+		log.WithError(err).Errorln("cannot setup wireguard device")
+		return err
+	}
+	defer cl.Close()
+	if err := cl.ConfigureDevice(iface, wgtypes.Config{Peers: []wgtypes.PeerConfig{next.devicePeer(peer)}}); err != nil {
+		log.WithError(err).Error("cannot configure peer")
+		return err
+	}
+	if st.Endpoints == nil {
+		st.Endpoints = make(map[string]string)
+	}
+	delete(st.Endpoints, peer.PublicKey.String())
+	if peer.Endpoint != nil {
+		st.Endpoints[peer.PublicKey.String()] = peer.Endpoint.String()
+	}
+
+	for _, dst := range next.allowedIPs(peer) {
+		rt := managedRoute(next, link, dst)
+		if err := netlink.RouteReplace(&rt); err != nil {
+			log.WithError(err).WithField("route", rt.Dst.String()).Error("cannot add/replace route")
+			return err
+		}
+		if !st.ownsRoute(rt) {
+			st.Routes = append(st.Routes, stateRoute(rt))
+		}
+		log.WithField("route", rt.Dst.String()).Info("route added/replaced")
+	}
+
+	for _, dst := range staleAllowedIPs {
+		rt := managedRoute(cfg, link, dst)
+		if !st.ownsRoute(rt) {
+			continue
+		}
+		if err := netlink.RouteDel(&rt); err != nil {
+			log.WithError(err).WithField("route", rt.Dst.String()).Error("cannot delete route")
+			return err
+		}
+		key := stateRoute(rt)
+		for i, owned := range st.Routes {
+			if owned == key {
+				st.Routes = append(st.Routes[:i], st.Routes[i+1:]...)
+				break
+			}
+		}
+		log.WithField("route", rt.Dst.String()).Info("route deleted")
+	}
+
+	if err := st.Save(iface); err != nil {
+		log.WithError(err).Error("cannot save interface state")
+		return err
+	}
+	log.Info("peer applied")
+	return nil
+}
+
+// devicePeer returns the peer as configured on the device: ExcludedIPs subtracted and, unless removed, a nil PresharedKey
+// and PersistentKeepaliveInterval set to zero, so an update clears them instead of keeping the old ones
+func (cfg *Config) devicePeer(peer wgtypes.PeerConfig) wgtypes.PeerConfig {
+	if peer.Remove {
+		return peer
+	}
+	peer.AllowedIPs = cfg.allowedIPs(peer)
+	if peer.PresharedKey == nil {
+		peer.PresharedKey = &wgtypes.Key{}
+	}
+	if peer.PersistentKeepaliveInterval == nil {
+		var zero time.Duration
+		peer.PersistentKeepaliveInterval = &zero
+	}
+	return peer
+}
@@ -0,0 +1,96 @@
+package wgquick
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestPeerDiff(t *testing.T) {
+	alice := wgtypes.PeerConfig{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.0.0.2/32, 192.168.1.0/24")}
+	bob := wgtypes.PeerConfig{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.0.0.3/32")}
+	peers := []wgtypes.PeerConfig{alice}
+
+	added, err := withPeer(peers, bob, false)
+	require.NoError(t, err)
+	assert.Equal(t, []wgtypes.PeerConfig{alice, bob}, added)
+	assert.Len(t, peers, 1, "original untouched")
+	_, err = withPeer(added, bob, false)
+	assert.EqualError(t, err, "peer "+bob.PublicKey.String()+" already exists")
+
+	moved := bob
+	moved.AllowedIPs = mustCIDRs(t, "10.0.0.3/32, 192.168.1.0/24")
+	updated, err := withPeer(added, moved, true)
+	require.NoError(t, err)
+	assert.Equal(t, []wgtypes.PeerConfig{alice, moved}, updated)
+	assert.Equal(t, bob, added[1], "original untouched")
+	_, err = withPeer(peers, bob, true)
+	assert.EqualError(t, err, "peer "+bob.PublicKey.String()+" not found")
+
+	removed, err := withoutPeer(updated, alice.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, []wgtypes.PeerConfig{moved}, removed)
+	assert.Equal(t, alice, updated[0], "original untouched")
+	_, err = withoutPeer(removed, alice.PublicKey)
+	assert.EqualError(t, err, "peer "+alice.PublicKey.String()+" not found")
+
+	// routes of AllowedIPs another peer still has stay
+	assert.Equal(t, mustCIDRs(t, "10.0.0.2/32"), staleAllowedIPs(&Config{}, removed, alice))
+	shrunk := alice
+	shrunk.AllowedIPs = mustCIDRs(t, "10.0.0.2/32")
+	updated, err = withPeer(peers, shrunk, true)
+	require.NoError(t, err)
+	assert.Equal(t, mustCIDRs(t, "192.168.1.0/24"), staleAllowedIPs(&Config{}, updated, alice))
+	assert.Empty(t, staleAllowedIPs(&Config{}, added, alice))
+}
+
+func TestPeerRemovedWithOptions(t *testing.T) {
+	alice := wgtypes.PeerConfig{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.0.0.2/32")}
+	bob := wgtypes.PeerConfig{PublicKey: mustKey(t), AllowedIPs: mustCIDRs(t, "10.0.0.3/32")}
+	cfg := &Config{
+		PeerOptions: map[wgtypes.Key]*PeerOptions{alice.PublicKey: {RouteMetric: 10}, bob.PublicKey: {RouteMetric: 20}},
+		PeerSources: map[wgtypes.Key]string{alice.PublicKey: "wg0.conf", bob.PublicKey: "wg0.conf.d/bob.conf"},
+	}
+	cfg.Peers = []wgtypes.PeerConfig{alice, bob}
+
+	next, err := cfg.withoutPeer(bob.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, []wgtypes.PeerConfig{alice}, next.Peers)
+	assert.Equal(t, map[wgtypes.Key]*PeerOptions{alice.PublicKey: {RouteMetric: 10}}, next.PeerOptions)
+	// the source is dropped after the write, it truncates the drop-in
+	assert.Len(t, next.PeerSources, 2)
+	delete(next.PeerSources, bob.PublicKey)
+	assert.Len(t, cfg.PeerSources, 2, "original untouched")
+	assert.Len(t, cfg.PeerOptions, 2, "original untouched")
+
+	_, err = next.withoutPeer(bob.PublicKey)
+	assert.EqualError(t, err, "peer "+bob.PublicKey.String()+" not found")
+}
+
+func TestDevicePeer(t *testing.T) {
+	psk := mustKey(t)
+	keepalive := 25 * time.Second
+	peer := wgtypes.PeerConfig{PublicKey: mustKey(t), PresharedKey: &psk, PersistentKeepaliveInterval: &keepalive, AllowedIPs: mustCIDRs(t, "0.0.0.0/0")}
+	cfg := &Config{PeerOptions: map[wgtypes.Key]*PeerOptions{peer.PublicKey: {ExcludedIPs: mustCIDRs(t, "128.0.0.0/1")}}}
+	cfg.Peers = []wgtypes.PeerConfig{peer}
+
+	dev := cfg.devicePeer(peer)
+	assert.Equal(t, &psk, dev.PresharedKey)
+	assert.Equal(t, &keepalive, dev.PersistentKeepaliveInterval)
+	assert.Equal(t, "0.0.0.0/1", FormatCIDRs(dev.AllowedIPs))
+
+	// nil clears the old values on update
+	peer.PresharedKey = nil
+	peer.PersistentKeepaliveInterval = nil
+	dev = cfg.devicePeer(peer)
+	assert.Equal(t, &wgtypes.Key{}, dev.PresharedKey)
+	require.NotNil(t, dev.PersistentKeepaliveInterval)
+	assert.Zero(t, *dev.PersistentKeepaliveInterval)
+	assert.Nil(t, peer.PresharedKey, "original untouched")
+
+	removed := wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true}
+	assert.Equal(t, removed, cfg.devicePeer(removed))
+}
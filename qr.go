@@ -0,0 +1,122 @@
+package wgquick
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// MobileText returns the config as understood by mobile wireguard clients. Hooks, SaveConfig and wg-quick-go specific directives
+// are stripped, mobile clients either ignore them or refuse the config. The warnings list what was stripped
+func (cfg *Config) MobileText() ([]byte, []string, error) {
+	var warnings []string
+	// mobile clients don't know ExcludedIPs, they get the expanded AllowedIPs
+	mobile := *cfg.withExcludedIPs()
+	for _, hook := range []directive{{"PreUp", cfg.PreUp}, {"PostUp", cfg.PostUp}, {"PreDown", cfg.PreDown}, {"PostDown", cfg.PostDown}} {
+		if hook.value != "" {
+			warnings = append(warnings, hook.key+" hook is ignored by mobile clients, stripped")
+		}
+	}
+	mobile.PreUp, mobile.PostUp, mobile.PreDown, mobile.PostDown = "", "", "", ""
+	if cfg.SaveConfig {
+		warnings = append(warnings, "SaveConfig is ignored by mobile clients, stripped")
+		mobile.SaveConfig = false
+	}
+	if cfg.Forwarding {
+		warnings = append(warnings, "Forwarding isn't supported by mobile clients, stripped")
+		mobile.Forwarding = false
+	}
+	if cfg.FirewallMark != nil {
+		warnings = append(warnings, "FwMark isn't supported by mobile clients, stripped")
+		mobile.FirewallMark = nil
+	}
+	if cfg.PendingPrivateKey != nil {
+		warnings = append(warnings, "PendingPrivateKey isn't supported by mobile clients, stripped")
+		mobile.PendingPrivateKey = nil
+		mobile.PendingKeysAt = time.Time{}
+	}
+	// mobile clients get the keys inline
+	mobile.PrivateKeyFile = ""
+	for _, peer := range cfg.Peers {
+		opts, ok := cfg.PeerOptions[peer.PublicKey]
+		if !ok {
+			continue
+		}
+		stripped := *opts
+		stripped.PresharedKeyFile = ""
+		stripped.ExcludedIPs = nil
+		if !stripped.empty() {
+			warnings = append(warnings, "peer "+peer.PublicKey.String()+": FallbackEndpoint, HealthCheck, RouteMetric and pending keys aren't supported by mobile clients, stripped")
+		}
+	}
+	mobile.PeerOptions = nil
+
+	text, err := mobile.MarshalText()
+	if err != nil {
+		return nil, nil, err
+	}
+	return text, warnings, nil
+}
+
+func (cfg *Config) qrCode() (*qrcode.QRCode, []string, error) {
+	text, warnings, err := cfg.MobileText()
+	if err != nil {
+		return nil, nil, err
+	}
+	code, err := qrcode.New(string(text), qrcode.Low)
+	if err != nil {
+		return nil, nil, err
+	}
+	return code, warnings, nil
+}
+
+// WriteQRTerminal renders MobileText as QR code for the terminal, two modules per character using UTF-8 half blocks.
+// Colors are forced with ANSI escapes, so the code scans on both dark and light terminal themes
+func (cfg *Config) WriteQRTerminal(w io.Writer) ([]string, error) {
+	code, warnings, err := cfg.qrCode()
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(w)
+	writeHalfBlocks(bw, code.Bitmap())
+	return warnings, bw.Flush()
+}
+
+// QRPNG renders MobileText as QR code PNG image, size is the image width and height in pixels
+func (cfg *Config) QRPNG(size int) ([]byte, []string, error) {
+	code, warnings, err := cfg.qrCode()
+	if err != nil {
+		return nil, nil, err
+	}
+	png, err := code.PNG(size)
+	if err != nil {
+		return nil, nil, err
+	}
+	return png, warnings, nil
+}
+
+// writeHalfBlocks draws light modules as white foreground on black background, rows below the bitmap are light quiet zone
+func writeHalfBlocks(w *bufio.Writer, bitmap [][]bool) {
+	light := func(row, col int) bool {
+		return row >= len(bitmap) || !bitmap[row][col]
+	}
+	for row := 0; row < len(bitmap); row += 2 {
+		w.WriteString("\x1b[97;40m")
+		for col := range bitmap[row] {
+			top, bottom := light(row, col), light(row+1, col)
+			switch {
+			case top && bottom:
+				w.WriteString("█")
+			case top:
+				w.WriteString("▀")
+			case bottom:
+				w.WriteString("▄")
+			default:
+				w.WriteString(" ")
+			}
+		}
+		w.WriteString("\x1b[0m\n")
+	}
+}
@@ -0,0 +1,65 @@
+package wgquick
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMobileText(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(testConfigs["simple"])))
+	text, warnings, err := c.MobileText()
+	require.NoError(t, err)
+	assert.Equal(t, testConfigs["simple"], string(text))
+	assert.Empty(t, warnings)
+
+	require.NoError(t, c.UnmarshalText([]byte(testConfigs["sample-3"])))
+	text, warnings, err = c.MobileText()
+	require.NoError(t, err)
+	assert.NotContains(t, string(text), "PostUp")
+	assert.Equal(t, []string{
+		"PostUp hook is ignored by mobile clients, stripped",
+		"PreDown hook is ignored by mobile clients, stripped",
+	}, warnings)
+	assert.NotEmpty(t, c.PostUp, "config itself isn't modified")
+
+	require.NoError(t, c.UnmarshalText([]byte(testConfigs["health-check"])))
+	text, warnings, err = c.MobileText()
+	require.NoError(t, err)
+	assert.NotContains(t, string(text), "HealthCheck")
+	assert.Len(t, warnings, 2)
+}
+
+func TestWriteHalfBlocks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+	writeHalfBlocks(w, [][]bool{
+		{false, true, false},
+		{false, false, true},
+		{true, false, false},
+	})
+	require.NoError(t, w.Flush())
+	assert.Equal(t, []string{
+		"\x1b[97;40m█▄▀\x1b[0m",
+		"\x1b[97;40m▄██\x1b[0m",
+		"",
+	}, strings.Split(buf.String(), "\n"))
+}
+
+func TestQR(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(testConfigs["simple"])))
+	png, _, err := c.QRPNG(256)
+	require.NoError(t, err)
+	assert.Equal(t, "\x89PNG", string(png[:4]))
+
+	buf := &bytes.Buffer{}
+	_, err = c.WriteQRTerminal(buf)
+	require.NoError(t, err)
+	assert.True(t, strings.Count(buf.String(), "\n") > 10)
+}
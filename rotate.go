@@ -0,0 +1,324 @@
+package wgquick
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DefaultRotateWindow is the Window the CLI stages private key rotations for, leaving time to update the remote peers
+const DefaultRotateWindow = 24 * time.Hour
+
+// RotateOptions select the keys Rotate replaces
+type RotateOptions struct {
+	// PrivateKey generates new interface keypair. Every peer has to learn the new public key
+	PrivateKey bool
+	// PresharedKeys generates new preshared key for the peers
+	PresharedKeys bool
+	// Peers limits preshared key rotation to these peers. Empty means all peers
+	Peers []wgtypes.Key
+	// Window stages the new keys for this long before they replace the current ones, so the remote peers can be
+	// updated in the meantime and switch at the same time. Zero switches right away, dropping the traffic until
+	// the remote peers are updated
+	Window time.Duration
+}
+
+// Rotation is the result of key rotation
+type Rotation struct {
+	// Config is the updated local config
+	Config *Config
+	// PreviousPublicKey is the interface public key before the rotation, the remote peers know the interface by it
+	PreviousPublicKey wgtypes.Key
+	// At is when the new keys replace the current ones, see Config.PendingKeysAt. Zero if they already did
+	At time.Time
+	// Remote holds the [Peer] section describing this interface after the rotation for every affected peer, by the peer public key
+	Remote map[wgtypes.Key]wgtypes.PeerConfig
+}
+
+// Rotate replaces the interface private key and/or peer preshared keys of the config file at path, writes the file
+// and syncs the interface. Changing the private key drops the current sessions of every peer right away, so without
+// Window the traffic stops until each remote peer picks up Rotation.Remote.
+// With Window the new keys are only staged as pending keys, the interface keeps the current ones until PendingKeysAt.
+// The remote configs get the same PendingKeysAt from UpdateRemote, so both sides switch together. Nothing switches
+// by itself at that time though, every side has to run CommitPendingKeys or Sync then, e.g. wg-quick -wait commit-keys
+func Rotate(path string, iface string, opts RotateOptions, logger logrus.FieldLogger) (*Rotation, error) {
+	log := logger.WithField("iface", iface)
+	lock, err := lockInterface(iface, "rotate", DefaultLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	cfg, err := ReadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := rotateKeys(cfg, opts, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(cfg, log); err != nil {
+		return nil, err
+	}
+	if err := WriteConfigFile(path, cfg); err != nil {
+		return nil, err
+	}
+	log.WithField("private_key", opts.PrivateKey).WithField("peers", len(r.Remote)).WithField("at", r.At).Info("keys rotated")
+	if err := syncLocked(cfg, iface, logger); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// CommitPendingKeys replaces the current keys in the config file at path with the pending ones right away,
+// writes the file and syncs the interface
+func CommitPendingKeys(path string, iface string, logger logrus.FieldLogger) error {
+	log := logger.WithField("iface", iface)
+	lock, err := lockInterface(iface, "commit-keys", DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	cfg, err := ReadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if cfg.PendingKeysAt.IsZero() {
+		return fmt.Errorf("config has no pending keys")
+	}
+	cfg.commitPendingKeys()
+	if err := validate(cfg, log); err != nil {
+		return err
+	}
+	if err := WriteConfigFile(path, cfg); err != nil {
+		return err
+	}
+	log.Info("pending keys committed")
+	return syncLocked(cfg, iface, logger)
+}
+
+// rotateKeys generates the new keys into cfg
+func rotateKeys(cfg *Config, opts RotateOptions, now time.Time) (*Rotation, error) {
+	if !opts.PrivateKey && !opts.PresharedKeys {
+		return nil, fmt.Errorf("nothing to rotate")
+	}
+	if cfg.PrivateKey == nil {
+		return nil, fmt.Errorf("config has no private key")
+	}
+	if !cfg.PendingKeysAt.IsZero() {
+		return nil, fmt.Errorf("keys already pending until %s, commit them first", toRFC3339(cfg.PendingKeysAt))
+	}
+	for _, key := range opts.Peers {
+		if findPeer(cfg, key) < 0 {
+			return nil, fmt.Errorf("peer %s not found", key)
+		}
+	}
+	// pending keys are kept in the config, which must not get the secrets of key files
+	if opts.Window > 0 && opts.PrivateKey && cfg.PrivateKeyFile != "" {
+		return nil, fmt.Errorf("private key read from %s, staging it would write the pending key into the config, rotate without window", cfg.PrivateKeyFile)
+	}
+	for _, peer := range cfg.Peers {
+		peerOpts, ok := cfg.PeerOptions[peer.PublicKey]
+		if opts.Window > 0 && opts.PresharedKeys && ok && peerOpts.PresharedKeyFile != "" && (len(opts.Peers) == 0 || containsKey(opts.Peers, peer.PublicKey)) {
+			return nil, fmt.Errorf("peer %s: preshared key read from %s, staging it would write the pending key into the config, rotate without window", peer.PublicKey, peerOpts.PresharedKeyFile)
+		}
+	}
+
+	r := &Rotation{
+		Config:            cfg,
+		PreviousPublicKey: cfg.PrivateKey.PublicKey(),
+		Remote:            make(map[wgtypes.Key]wgtypes.PeerConfig),
+	}
+	staged := opts.Window > 0
+	if staged {
+		r.At = now.Add(opts.Window)
+		cfg.PendingKeysAt = r.At
+	}
+
+	publicKey := r.PreviousPublicKey
+	if opts.PrivateKey {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		publicKey = key.PublicKey()
+		if staged {
+			cfg.PendingPrivateKey = &key
+		} else {
+			cfg.PrivateKey = &key
+		}
+	}
+	for i := range cfg.Peers {
+		peer := &cfg.Peers[i]
+		psk := peer.PresharedKey
+		if opts.PresharedKeys && (len(opts.Peers) == 0 || containsKey(opts.Peers, peer.PublicKey)) {
+			key, err := wgtypes.GenerateKey()
+			if err != nil {
+				return nil, err
+			}
+			psk = &key
+			if staged {
+				cfg.Options(peer.PublicKey).PendingPresharedKey = psk
+			} else {
+				peer.PresharedKey = psk
+			}
+		} else if !opts.PrivateKey {
+			continue
+		}
+		r.Remote[peer.PublicKey] = wgtypes.PeerConfig{
+			PublicKey:    publicKey,
+			PresharedKey: psk,
+		}
+	}
+	return r, nil
+}
+
+// commitPendingKeys replaces the current keys with the pending ones
+func (cfg *Config) commitPendingKeys() {
+	if cfg.PendingPrivateKey != nil {
+		cfg.PrivateKey = cfg.PendingPrivateKey
+	}
+	for i := range cfg.Peers {
+		peer := &cfg.Peers[i]
+		opts, ok := cfg.PeerOptions[peer.PublicKey]
+		if !ok {
+			continue
+		}
+		if opts.PendingPresharedKey != nil {
+			peer.PresharedKey = opts.PendingPresharedKey
+			opts.PendingPresharedKey = nil
+		}
+		if opts.PendingPublicKey != nil {
+			key := *opts.PendingPublicKey
+			opts.PendingPublicKey = nil
+			cfg.renamePeer(peer, key)
+		}
+		if opts.empty() {
+			delete(cfg.PeerOptions, peer.PublicKey)
+		}
+	}
+	cfg.PendingPrivateKey = nil
+	cfg.PendingKeysAt = time.Time{}
+}
+
+// renamePeer changes the public key of the peer, moving its PeerOptions and PeerSources along
+func (cfg *Config) renamePeer(peer *wgtypes.PeerConfig, key wgtypes.Key) {
+	if opts, ok := cfg.PeerOptions[peer.PublicKey]; ok {
+		delete(cfg.PeerOptions, peer.PublicKey)
+		cfg.PeerOptions[key] = opts
+	}
+	if source, ok := cfg.PeerSources[peer.PublicKey]; ok {
+		delete(cfg.PeerSources, peer.PublicKey)
+		cfg.PeerSources[key] = source
+	}
+	peer.PublicKey = key
+}
+
+// PendingKeysOverdue reports whether the config still has pending keys whose PendingKeysAt has passed.
+// Remote peers switch at that time, so until the keys are committed or synced they can't talk to this interface
+func (cfg *Config) PendingKeysOverdue(now time.Time) bool {
+	return !cfg.PendingKeysAt.IsZero() && !now.Before(cfg.PendingKeysAt)
+}
+
+// withDueKeys returns copy of the config with the pending keys committed once PendingKeysAt has passed,
+// otherwise the config itself
+func (cfg *Config) withDueKeys(now time.Time) *Config {
+	if !cfg.PendingKeysOverdue(now) {
+		return cfg
+	}
+	due := *cfg
+	due.Peers = append([]wgtypes.PeerConfig(nil), cfg.Peers...)
+	due.PeerOptions = make(map[wgtypes.Key]*PeerOptions, len(cfg.PeerOptions))
+	for key, opts := range cfg.PeerOptions {
+		copied := *opts
+		due.PeerOptions[key] = &copied
+	}
+	if cfg.PeerSources != nil {
+		due.PeerSources = make(map[wgtypes.Key]string, len(cfg.PeerSources))
+		for key, source := range cfg.PeerSources {
+			due.PeerSources[key] = source
+		}
+	}
+	due.commitPendingKeys()
+	return &due
+}
+
+func containsKey(keys []wgtypes.Key, key wgtypes.Key) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateRemote applies the rotation to the config of a remote peer, replacing the public and preshared key of its peer
+// representing this interface, or staging them as pending keys switching at Rotation.At.
+// Returns false if the config doesn't belong to any affected peer
+func (r *Rotation) UpdateRemote(remote *Config) (bool, error) {
+	if remote.PrivateKey == nil {
+		return false, nil
+	}
+	section, ok := r.Remote[remote.PrivateKey.PublicKey()]
+	if !ok {
+		return false, nil
+	}
+	idx := findPeer(remote, r.PreviousPublicKey)
+	if idx < 0 {
+		return false, fmt.Errorf("remote config has no peer %s", r.PreviousPublicKey)
+	}
+	peer := &remote.Peers[idx]
+
+	if !r.At.IsZero() {
+		if !remote.PendingKeysAt.IsZero() && !remote.PendingKeysAt.Equal(r.At) {
+			return false, fmt.Errorf("remote config already has keys pending until %s", toRFC3339(remote.PendingKeysAt))
+		}
+		pskChanged := section.PresharedKey != nil && (peer.PresharedKey == nil || *section.PresharedKey != *peer.PresharedKey)
+		if opts, ok := remote.PeerOptions[peer.PublicKey]; ok && pskChanged && opts.PresharedKeyFile != "" {
+			return false, fmt.Errorf("remote preshared key read from %s, staging it would write the pending key into the config", opts.PresharedKeyFile)
+		}
+		remote.PendingKeysAt = r.At
+		opts := remote.Options(peer.PublicKey)
+		if section.PublicKey != peer.PublicKey {
+			key := section.PublicKey
+			opts.PendingPublicKey = &key
+		}
+		if pskChanged {
+			opts.PendingPresharedKey = section.PresharedKey
+		}
+		return true, nil
+	}
+
+	remote.renamePeer(peer, section.PublicKey)
+	peer.PresharedKey = section.PresharedKey
+	return true, nil
+}
+
+// RemoteSection returns the changed directives of the remote peer config, as wg-quick sections to merge into
+// its config
+func (r *Rotation) RemoteSection(key wgtypes.Key) ([]byte, error) {
+	section, ok := r.Remote[key]
+	if !ok {
+		return nil, fmt.Errorf("peer %s not affected by the rotation", key)
+	}
+	b := &bytes.Buffer{}
+	if r.At.IsZero() {
+		fmt.Fprintf(b, "# replaces PublicKey = %s\n[Peer]\nPublicKey = %s\n", r.PreviousPublicKey, section.PublicKey)
+		if section.PresharedKey != nil {
+			fmt.Fprintf(b, "PresharedKey = %s\n", section.PresharedKey)
+		}
+		return b.Bytes(), nil
+	}
+	fmt.Fprintf(b, "[Interface]\nPendingKeysAt = %s\n\n[Peer]\nPublicKey = %s\n", toRFC3339(r.At), r.PreviousPublicKey)
+	if section.PublicKey != r.PreviousPublicKey {
+		fmt.Fprintf(b, "PendingPublicKey = %s\n", section.PublicKey)
+	}
+	if section.PresharedKey != nil {
+		fmt.Fprintf(b, "PendingPresharedKey = %s\n", section.PresharedKey)
+	}
+	return b.Bytes(), nil
+}
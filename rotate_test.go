@@ -0,0 +1,157 @@
+package wgquick
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestRotateKeys(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, cfg.UnmarshalText([]byte(testConfigs["sample-2"])))
+	oldKey := *cfg.PrivateKey
+	oldPSK := cfg.Peers[0].PresharedKey
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	r, err := rotateKeys(cfg, RotateOptions{PrivateKey: true, PresharedKeys: true, Peers: []wgtypes.Key{cfg.Peers[0].PublicKey}, Window: 7 * 24 * time.Hour}, now)
+	require.NoError(t, err)
+	assert.Equal(t, oldKey, *cfg.PrivateKey, "current key stays live")
+	assert.NotEqual(t, oldKey, *cfg.PendingPrivateKey)
+	assert.Equal(t, oldKey.PublicKey(), r.PreviousPublicKey)
+	assert.Equal(t, now.Add(7*24*time.Hour), cfg.PendingKeysAt)
+	assert.Equal(t, cfg.PendingKeysAt, r.At)
+	require.Len(t, r.Remote, len(cfg.Peers), "private key rotation affects every peer")
+	for _, peer := range cfg.Peers {
+		assert.Equal(t, cfg.PendingPrivateKey.PublicKey(), r.Remote[peer.PublicKey].PublicKey)
+	}
+	assert.Equal(t, oldPSK, cfg.Peers[0].PresharedKey)
+	pending := cfg.PeerOptions[cfg.Peers[0].PublicKey].PendingPresharedKey
+	require.NotNil(t, pending)
+	assert.Equal(t, pending, r.Remote[cfg.Peers[0].PublicKey].PresharedKey)
+	assert.Nil(t, cfg.PeerOptions[cfg.Peers[1].PublicKey], "not rotated")
+
+	// pending keys survive encoding
+	decoded := &Config{}
+	require.NoError(t, decoded.UnmarshalText([]byte(cfg.String())))
+	assert.Equal(t, cfg.String(), decoded.String())
+	assert.True(t, cfg.PendingKeysAt.Equal(decoded.PendingKeysAt))
+	b, err := cfg.Encode(FormatYAML)
+	require.NoError(t, err)
+	decoded, err = DecodeConfig(FormatYAML, "", b)
+	require.NoError(t, err)
+	assert.Equal(t, cfg.String(), decoded.String())
+
+	_, err = rotateKeys(cfg, RotateOptions{PresharedKeys: true}, now)
+	assert.EqualError(t, err, "keys already pending until 2026-10-26T12:00:00Z, commit them first")
+
+	// sync keeps the current keys until the pending ones are due
+	assert.True(t, cfg == cfg.withDueKeys(now.Add(time.Hour)))
+	due := cfg.withDueKeys(now.Add(7 * 24 * time.Hour))
+	assert.Equal(t, *cfg.PendingPrivateKey, *due.PrivateKey)
+	assert.Equal(t, pending, due.Peers[0].PresharedKey)
+	assert.Nil(t, due.PendingPrivateKey)
+	assert.Empty(t, due.PeerOptions)
+	assert.Equal(t, oldKey, *cfg.PrivateKey, "config itself untouched")
+	assert.NotNil(t, cfg.PeerOptions[cfg.Peers[0].PublicKey].PendingPresharedKey)
+
+	cfg.commitPendingKeys()
+	assert.Equal(t, due.String(), cfg.String())
+
+	r, err = rotateKeys(cfg, RotateOptions{PresharedKeys: true}, now)
+	require.NoError(t, err)
+	assert.True(t, r.At.IsZero())
+	assert.Equal(t, r.Remote[cfg.Peers[0].PublicKey].PresharedKey, cfg.Peers[0].PresharedKey, "switched right away")
+	assert.Empty(t, cfg.PeerOptions)
+
+	_, err = rotateKeys(cfg, RotateOptions{}, now)
+	assert.EqualError(t, err, "nothing to rotate")
+}
+
+func TestRotationUpdateRemote(t *testing.T) {
+	newRemote := func(local *Config) (*Config, wgtypes.Key) {
+		remoteKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		local.Peers[0].PublicKey = remoteKey.PublicKey()
+		remote := &Config{Address: mustCIDRs(t, "10.200.100.1/24")}
+		remote.PrivateKey = &remoteKey
+		remote.Peers = []wgtypes.PeerConfig{{PublicKey: local.PrivateKey.PublicKey(), PresharedKey: local.Peers[0].PresharedKey, AllowedIPs: mustCIDRs(t, "10.200.100.8/32")}}
+		remote.Options(local.PrivateKey.PublicKey()).RouteMetric = 10
+		return remote, remoteKey.PublicKey()
+	}
+
+	t.Run("immediate", func(t *testing.T) {
+		local := &Config{}
+		require.NoError(t, local.UnmarshalText([]byte(testConfigs["simple"])))
+		remote, remoteKey := newRemote(local)
+		other := &Config{}
+		require.NoError(t, other.UnmarshalText([]byte(testConfigs["sample-2"])))
+
+		remote.PeerSources = map[wgtypes.Key]string{remote.Peers[0].PublicKey: "/etc/wireguard/wg0.conf.d/laptop.conf"}
+		r, err := rotateKeys(local, RotateOptions{PrivateKey: true, PresharedKeys: true}, time.Now())
+		require.NoError(t, err)
+
+		updated, err := r.UpdateRemote(other)
+		require.NoError(t, err)
+		assert.False(t, updated)
+		updated, err = r.UpdateRemote(remote)
+		require.NoError(t, err)
+		assert.True(t, updated)
+		assert.Equal(t, local.PrivateKey.PublicKey(), remote.Peers[0].PublicKey)
+		assert.Equal(t, local.Peers[0].PresharedKey, remote.Peers[0].PresharedKey)
+		assert.Equal(t, 10, remote.PeerOptions[remote.Peers[0].PublicKey].RouteMetric, "options follow the new key")
+		assert.Len(t, remote.PeerOptions, 1)
+		assert.Equal(t, map[wgtypes.Key]string{remote.Peers[0].PublicKey: "/etc/wireguard/wg0.conf.d/laptop.conf"}, remote.PeerSources, "stays in its drop-in")
+
+		section, err := r.RemoteSection(remoteKey)
+		require.NoError(t, err)
+		assert.Equal(t, "# replaces PublicKey = "+r.PreviousPublicKey.String()+"\n[Peer]\nPublicKey = "+local.PrivateKey.PublicKey().String()+
+			"\nPresharedKey = "+local.Peers[0].PresharedKey.String()+"\n", string(section))
+	})
+
+	t.Run("staged", func(t *testing.T) {
+		local := &Config{}
+		require.NoError(t, local.UnmarshalText([]byte(testConfigs["simple"])))
+		remote, remoteKey := newRemote(local)
+		oldPublicKey := local.PrivateKey.PublicKey()
+		at := time.Date(2026, 10, 26, 12, 0, 0, 0, time.UTC)
+
+		r, err := rotateKeys(local, RotateOptions{PrivateKey: true, PresharedKeys: true, Window: time.Hour}, at.Add(-time.Hour))
+		require.NoError(t, err)
+		newPublicKey := local.PendingPrivateKey.PublicKey()
+		newPSK := *local.PeerOptions[remoteKey].PendingPresharedKey
+
+		updated, err := r.UpdateRemote(remote)
+		require.NoError(t, err)
+		assert.True(t, updated)
+		assert.Equal(t, oldPublicKey, remote.Peers[0].PublicKey, "remote keeps the current key until the switch")
+		assert.Equal(t, at, remote.PendingKeysAt)
+		opts := remote.PeerOptions[oldPublicKey]
+		assert.Equal(t, newPublicKey, *opts.PendingPublicKey)
+		assert.Equal(t, newPSK, *opts.PendingPresharedKey)
+
+		due := remote.withDueKeys(at)
+		assert.Equal(t, newPublicKey, due.Peers[0].PublicKey)
+		assert.Equal(t, newPSK, *due.Peers[0].PresharedKey)
+		assert.Equal(t, map[wgtypes.Key]*PeerOptions{newPublicKey: {RouteMetric: 10}}, due.PeerOptions, "options follow the new key")
+		assert.Equal(t, *local.withDueKeys(at).PrivateKey, *local.PendingPrivateKey, "both sides switch together")
+
+		section, err := r.RemoteSection(remoteKey)
+		require.NoError(t, err)
+		assert.Equal(t, "[Interface]\nPendingKeysAt = 2026-10-26T12:00:00Z\n\n[Peer]\nPublicKey = "+oldPublicKey.String()+
+			"\nPendingPublicKey = "+newPublicKey.String()+"\nPendingPresharedKey = "+newPSK.String()+"\n", string(section))
+
+		remote.PendingKeysAt = time.Time{}
+		remote.PeerOptions = nil
+		remote.Options(oldPublicKey).PresharedKeyFile = "peer.psk"
+		_, err = r.UpdateRemote(remote)
+		assert.EqualError(t, err, "remote preshared key read from peer.psk, staging it would write the pending key into the config")
+		assert.True(t, remote.PendingKeysAt.IsZero(), "untouched")
+
+		remote.PendingKeysAt = at.Add(time.Minute)
+		_, err = r.UpdateRemote(remote)
+		assert.EqualError(t, err, "remote config already has keys pending until 2026-10-26T12:01:00Z")
+	})
+}
@@ -0,0 +1,120 @@
+package wgquick
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/vishvananda/netlink"
+)
+
+// StateDir holds per interface state files, <StateDir>/<iface>.state
+var StateDir = "/run/wg-quick"
+
+// State records kernel objects this library created for an interface. Sync only deletes objects recorded here, unless Config.Exclusive is set
+type State struct {
+	Addresses []string     `json:"addresses,omitempty"`
+	Routes    []StateRoute `json:"routes,omitempty"`
+	// Endpoints are peer endpoints from the last synced config, by peer public key. Endpoints are only pushed to the device when they change in the config
+	Endpoints map[string]string `json:"endpoints,omitempty"`
+	// Unhealthy are public keys of peers failing their HealthCheck, whose AllowedIPs aren't routed
+	Unhealthy []string `json:"unhealthy,omitempty"`
+	// Applied is the config last applied by a Manager
+	Applied *AppliedConfig `json:"applied,omitempty"`
+}
+
+// AppliedConfig identifies the config a Manager applied to the interface, so later Managers skip unchanged configs
+// and bring the interface down once its config file is gone
+type AppliedConfig struct {
+	// Dir is the managed directory holding the config file
+	Dir string `json:"dir"`
+	// Hash is sha256 of the config, see configHash
+	Hash string `json:"hash"`
+	// DNS, PreDown and PostDown are what Down needs from the config
+	DNS      []string `json:"dns,omitempty"`
+	PreDown  string   `json:"preDown,omitempty"`
+	PostDown string   `json:"postDown,omitempty"`
+}
+
+// StateRoute identifies managed route
+type StateRoute struct {
+	Dst      string `json:"dst"`
+	Table    int    `json:"table"`
+	Protocol int    `json:"protocol"`
+	Metric   int    `json:"metric"`
+}
+
+func statePath(iface string) string {
+	return filepath.Join(StateDir, iface+".state")
+}
+
+// LoadState reads the interface state. Missing state file means nothing is owned yet
+func LoadState(iface string) (*State, error) {
+	b, err := ioutil.ReadFile(statePath(iface))
+	if os.IsNotExist(err) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := &State{}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Save atomically writes the interface state
+func (st *State) Save(iface string) error {
+	if err := os.MkdirAll(StateDir, 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := statePath(iface) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath(iface))
+}
+
+// RemoveState deletes the interface state, e.g. once the link is gone
+func RemoveState(iface string) error {
+	err := os.Remove(statePath(iface))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (st *State) ownsAddress(addr *net.IPNet) bool {
+	for _, a := range st.Addresses {
+		if a == addr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func stateRoute(rt netlink.Route) StateRoute {
+	return StateRoute{
+		Dst:      rt.Dst.String(),
+		Table:    rt.Table,
+		Protocol: rt.Protocol,
+		Metric:   routePriority(rt),
+	}
+}
+
+func (st *State) ownsRoute(rt netlink.Route) bool {
+	key := stateRoute(rt)
+	for _, r := range st.Routes {
+		if r == key {
+			return true
+		}
+	}
+	return false
+}
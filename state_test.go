@@ -0,0 +1,57 @@
+package wgquick
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg-quick-state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(old string) { StateDir = old }(StateDir)
+	StateDir = dir
+
+	st, err := LoadState("wg0")
+	require.NoError(t, err)
+	assert.Equal(t, &State{}, st)
+
+	_, dst, _ := net.ParseCIDR("10.0.0.0/24")
+	rt := netlink.Route{Dst: dst, Table: unix.RT_CLASS_MAIN, Protocol: unix.RTPROT_BOOT}
+	st.Addresses = []string{"10.0.0.1/24"}
+	st.Routes = []StateRoute{stateRoute(rt)}
+	require.NoError(t, st.Save("wg0"))
+
+	st, err = LoadState("wg0")
+	require.NoError(t, err)
+	assert.True(t, st.ownsRoute(rt))
+	rt.Priority = 10
+	assert.False(t, st.ownsRoute(rt))
+	ip, addr, _ := net.ParseCIDR("10.0.0.1/24")
+	addr.IP = ip
+	assert.True(t, st.ownsAddress(addr))
+
+	// IPv6 routes added without metric are reported with the kernel default one
+	_, dst6, _ := net.ParseCIDR("fd00::/64")
+	rt6 := netlink.Route{Dst: dst6}
+	fillRouteDefaults(&rt6)
+	assert.Equal(t, 1024, rt6.Priority)
+	st.Routes = append(st.Routes, stateRoute(netlink.Route{Dst: dst6, Table: unix.RT_CLASS_MAIN, Protocol: unix.RTPROT_BOOT}))
+	assert.True(t, st.ownsRoute(rt6))
+	reported := netlink.Route{Dst: dst6, Table: unix.RT_CLASS_MAIN, Protocol: unix.RTPROT_BOOT, Type: unix.RTN_UNICAST, Priority: 1024}
+	assert.True(t, st.ownsRoute(reported))
+	assert.True(t, reported.Equal(rt6), "wanted route matches the kernel one")
+
+	require.NoError(t, RemoveState("wg0"))
+	require.NoError(t, RemoveState("wg0"))
+	st, err = LoadState("wg0")
+	require.NoError(t, err)
+	assert.Empty(t, st.Addresses)
+}
@@ -0,0 +1,71 @@
+package wgquick
+
+import (
+	"net"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// PeerStatus is the runtime state of single peer, matched with the config
+type PeerStatus struct {
+	PublicKey wgtypes.Key
+	// Source is the config file the peer was read from, see Config.PeerSources. Empty for peers only on the device
+	Source string
+	// Configured is set if the peer is in the config, otherwise it's only on the device and the next Sync removes it
+	Configured bool
+	// Active is set if the peer is on the device, otherwise the next Sync adds it
+	Active        bool
+	Endpoint      *net.UDPAddr
+	AllowedIPs    []net.IPNet
+	LastHandshake time.Time
+	ReceiveBytes  int64
+	TransmitBytes int64
+}
+
+// Status reads the device and matches its peers with the config. Configured peers come first in the config order,
+// followed by the peers only on the device
+func Status(client DeviceReader, cfg *Config, iface string) ([]PeerStatus, error) {
+	dev, err := client.Device(iface)
+	if err != nil {
+		return nil, err
+	}
+	devPeers := make(map[wgtypes.Key]wgtypes.Peer, len(dev.Peers))
+	for _, peer := range dev.Peers {
+		devPeers[peer.PublicKey] = peer
+	}
+
+	statuses := make([]PeerStatus, 0, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		st := PeerStatus{
+			PublicKey:  peer.PublicKey,
+			Source:     cfg.PeerSources[peer.PublicKey],
+			Configured: true,
+			Endpoint:   peer.Endpoint,
+			AllowedIPs: cfg.allowedIPs(peer),
+		}
+		if devPeer, ok := devPeers[peer.PublicKey]; ok {
+			st.fill(devPeer)
+			delete(devPeers, peer.PublicKey)
+		}
+		statuses = append(statuses, st)
+	}
+	for _, peer := range dev.Peers {
+		if _, ok := devPeers[peer.PublicKey]; !ok {
+			continue
+		}
+		st := PeerStatus{PublicKey: peer.PublicKey}
+		st.fill(peer)
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+func (st *PeerStatus) fill(peer wgtypes.Peer) {
+	st.Active = true
+	st.Endpoint = peer.Endpoint
+	st.AllowedIPs = peer.AllowedIPs
+	st.LastHandshake = peer.LastHandshakeTime
+	st.ReceiveBytes = peer.ReceiveBytes
+	st.TransmitBytes = peer.TransmitBytes
+}
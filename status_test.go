@@ -0,0 +1,43 @@
+package wgquick
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type fakeDevice wgtypes.Device
+
+func (f *fakeDevice) Device(name string) (*wgtypes.Device, error) {
+	dev := wgtypes.Device(*f)
+	return &dev, nil
+}
+
+func TestStatus(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, cfg.UnmarshalText([]byte(testConfigs["sample-2"])))
+	cfg.PeerSources = map[wgtypes.Key]string{cfg.Peers[1].PublicKey: "/etc/wireguard/wg0.conf.d/team.conf"}
+	stray := mustKey(t)
+	handshake := time.Unix(1000, 0)
+
+	statuses, err := Status(&fakeDevice{Peers: []wgtypes.Peer{
+		{PublicKey: stray},
+		{PublicKey: cfg.Peers[1].PublicKey, LastHandshakeTime: handshake, ReceiveBytes: 10},
+	}}, cfg, "wg0")
+	require.NoError(t, err)
+	require.Len(t, statuses, len(cfg.Peers)+1)
+
+	assert.True(t, statuses[0].Configured)
+	assert.False(t, statuses[0].Active, "not synced yet")
+	assert.Equal(t, "/etc/wireguard/wg0.conf.d/team.conf", statuses[1].Source)
+	assert.True(t, statuses[1].Active)
+	assert.Equal(t, handshake, statuses[1].LastHandshake)
+	assert.Equal(t, int64(10), statuses[1].ReceiveBytes)
+	last := statuses[len(statuses)-1]
+	assert.Equal(t, stray, last.PublicKey)
+	assert.False(t, last.Configured)
+	assert.Empty(t, last.Source)
+}
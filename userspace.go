@@ -0,0 +1,150 @@
+package wgquick
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/ipc"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// UserspaceMode controls when the embedded wireguard-go implementation is used instead of the kernel module
+type UserspaceMode int
+
+const (
+	// UserspaceAuto uses the kernel module, falling back to wireguard-go when the kernel doesn't support wireguard links
+	UserspaceAuto UserspaceMode = iota
+	// UserspaceNever only uses the kernel module
+	UserspaceNever
+	// UserspaceAlways only uses wireguard-go
+	UserspaceAlways
+)
+
+// defaultUserspaceMTU is wireguard-go default, used when the config doesn't set MTU
+const defaultUserspaceMTU = 1420
+
+// uapiSocketDir is where wireguard-go creates <iface>.sock UAPI sockets, and where wgctrl looks for them
+const uapiSocketDir = "/var/run/wireguard"
+
+type userspaceDevice struct {
+	dev  *device.Device
+	uapi net.Listener
+	done chan struct{}
+}
+
+var (
+	userspaceMu      sync.Mutex
+	userspaceDevices = make(map[string]*userspaceDevice)
+)
+
+// isKernelUnsupported reports whether link creation failed since the kernel has no wireguard support.
+// Besides EOPNOTSUPP for missing module, containers and some kernels refuse wireguard links with EPERM or ENODEV.
+// netlink returns bare syscall.Errno
+func isKernelUnsupported(err error) bool {
+	return err == unix.EOPNOTSUPP || err == unix.EPERM || err == unix.ENODEV
+}
+
+// startUserspace creates TUN device named iface, driven by in process wireguard-go and configurable through its UAPI socket just like kernel device.
+// The device lives as long as this process, or until stopUserspace
+func startUserspace(iface string, mtu int, log logrus.FieldLogger) error {
+	if mtu == 0 {
+		mtu = defaultUserspaceMTU
+	}
+	tunDev, err := tun.CreateTUN(iface, mtu)
+	if err != nil {
+		return fmt.Errorf("cannot create TUN device: %v", err)
+	}
+
+	dev := device.NewDevice(tunDev, device.NewLogger(device.LogLevelError, fmt.Sprintf("(%s) ", iface)))
+	uapiFile, err := ipc.UAPIOpen(iface)
+	if err != nil {
+		dev.Close()
+		return fmt.Errorf("cannot open UAPI socket: %v", err)
+	}
+	uapi, err := ipc.UAPIListen(iface, uapiFile)
+	if err != nil {
+		uapiFile.Close()
+		dev.Close()
+		return fmt.Errorf("cannot listen on UAPI socket: %v", err)
+	}
+
+	ud := &userspaceDevice{dev: dev, uapi: uapi, done: make(chan struct{})}
+	userspaceMu.Lock()
+	userspaceDevices[iface] = ud
+	userspaceMu.Unlock()
+
+	go func() {
+		for {
+			conn, err := uapi.Accept()
+			if err != nil {
+				return
+			}
+			go dev.IpcHandle(conn)
+		}
+	}()
+	go func() {
+		<-dev.Wait()
+		uapi.Close()
+		userspaceMu.Lock()
+		if userspaceDevices[iface] == ud {
+			delete(userspaceDevices, iface)
+		}
+		userspaceMu.Unlock()
+		close(ud.done)
+	}()
+	log.Info("started userspace wireguard-go device")
+	return nil
+}
+
+// stopUserspace closes the userspace device started by this process. Returns false if there's no such device
+func stopUserspace(iface string) bool {
+	userspaceMu.Lock()
+	ud, ok := userspaceDevices[iface]
+	userspaceMu.Unlock()
+	if !ok {
+		return false
+	}
+	ud.dev.Close()
+	<-ud.done
+	return true
+}
+
+// UserspaceDone returns channel closed once the userspace device for iface, started by this process, is closed.
+// Returns nil if this process doesn't run userspace device for iface. Since the device lives inside this process,
+// callers like the CLI have to keep running until the channel is closed
+func UserspaceDone(iface string) <-chan struct{} {
+	userspaceMu.Lock()
+	defer userspaceMu.Unlock()
+	ud, ok := userspaceDevices[iface]
+	if !ok {
+		return nil
+	}
+	return ud.done
+}
+
+// removeUAPISocket cleans up socket left behind by userspace device from another process
+func removeUAPISocket(iface string, log logrus.FieldLogger) {
+	path := filepath.Join(uapiSocketDir, iface+".sock")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).WithField("path", path).Warn("cannot remove UAPI socket")
+	}
+}
+
+// UserspaceInterfaces returns the interfaces with userspace device started by this process, sorted
+func UserspaceInterfaces() []string {
+	userspaceMu.Lock()
+	defer userspaceMu.Unlock()
+	ifaces := make([]string, 0, len(userspaceDevices))
+	for iface := range userspaceDevices {
+		ifaces = append(ifaces, iface)
+	}
+	sort.Strings(ifaces)
+	return ifaces
+}
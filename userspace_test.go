@@ -0,0 +1,19 @@
+package wgquick
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestIsKernelUnsupported(t *testing.T) {
+	for _, err := range []error{unix.EOPNOTSUPP, unix.EPERM, unix.ENODEV} {
+		assert.True(t, isKernelUnsupported(err), err.Error())
+	}
+	for _, err := range []error{nil, unix.EEXIST, unix.EINVAL, errors.New("operation not supported"), fmt.Errorf("cannot add link: %v", unix.ENODEV)} {
+		assert.False(t, isKernelUnsupported(err), "%v", err)
+	}
+}
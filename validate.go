@@ -0,0 +1,218 @@
+package wgquick
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Problem is single semantic issue in the config
+type Problem struct {
+	// Peer is index into Config.Peers, or -1 for interface level problems
+	Peer int
+	// Directive the problem is about, e.g. AllowedIPs
+	Directive string
+	Message   string
+}
+
+func (p Problem) String() string {
+	if p.Peer < 0 {
+		return fmt.Sprintf("[Interface] %s: %s", p.Directive, p.Message)
+	}
+	return fmt.Sprintf("[Peer #%d] %s: %s", p.Peer+1, p.Directive, p.Message)
+}
+
+// Validation is the result of Config.Validate. Errors make the config unusable, Warnings are likely mistakes
+type Validation struct {
+	Errors   []Problem
+	Warnings []Problem
+}
+
+// ValidationError is returned by Up and Sync for configs failing validation
+type ValidationError []Problem
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, p := range e {
+		msgs[i] = p.String()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// Err returns ValidationError if there are any errors
+func (v *Validation) Err() error {
+	if len(v.Errors) == 0 {
+		return nil
+	}
+	return ValidationError(v.Errors)
+}
+
+func (v *Validation) errorf(peer int, directive string, format string, args ...interface{}) {
+	v.Errors = append(v.Errors, Problem{Peer: peer, Directive: directive, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *Validation) warnf(peer int, directive string, format string, args ...interface{}) {
+	v.Warnings = append(v.Warnings, Problem{Peer: peer, Directive: directive, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the config semantics, returning every problem found. UnmarshalText only checks the syntax
+func (cfg *Config) Validate() *Validation {
+	v := &Validation{}
+	var zero wgtypes.Key
+	cfg = cfg.withExcludedIPs()
+
+	var ownKey wgtypes.Key
+	if cfg.PrivateKey == nil || *cfg.PrivateKey == zero {
+		v.errorf(-1, "PrivateKey", "missing private key")
+	} else {
+		ownKey = cfg.PrivateKey.PublicKey()
+	}
+	if cfg.ListenPort != nil && (*cfg.ListenPort < 0 || *cfg.ListenPort > 65535) {
+		v.errorf(-1, "ListenPort", "port %d out of range 0-65535", *cfg.ListenPort)
+	}
+	if cfg.FirewallMark != nil && (*cfg.FirewallMark < 0 || *cfg.FirewallMark > 0xffffffff) {
+		v.errorf(-1, "FwMark", "mark %d out of range 0-0xffffffff", *cfg.FirewallMark)
+	}
+	if cfg.MTU < 0 {
+		v.errorf(-1, "MTU", "negative MTU %d", cfg.MTU)
+	}
+	for _, addr := range cfg.Address {
+		ones, bits := addr.Mask.Size()
+		if bits-ones > 1 && addr.IP.Mask(addr.Mask).Equal(addr.IP) {
+			v.errorf(-1, "Address", "%s has no host bits, it's the network address", addr.String())
+		}
+	}
+
+	seen := make(map[wgtypes.Key]int, len(cfg.Peers))
+	prefixes := make(map[string]int)
+	for i, peer := range cfg.Peers {
+		switch {
+		case peer.PublicKey == zero:
+			v.errorf(i, "PublicKey", "missing public key")
+		case ownKey != zero && peer.PublicKey == ownKey:
+			v.errorf(i, "PublicKey", "peer uses this interface's own public key")
+		default:
+			if first, ok := seen[peer.PublicKey]; ok {
+				v.errorf(i, "PublicKey", "duplicate public key %s, first used by peer #%d", peer.PublicKey, first+1)
+			}
+			seen[peer.PublicKey] = i
+		}
+
+		for _, allowed := range peer.AllowedIPs {
+			network := net.IPNet{IP: allowed.IP.Mask(allowed.Mask), Mask: allowed.Mask}
+			if first, ok := prefixes[network.String()]; ok && first != i {
+				v.errorf(i, "AllowedIPs", "%s is already allowed for peer #%d, only one peer gets it", network.String(), first+1)
+				continue
+			}
+			prefixes[network.String()] = i
+		}
+
+		if opts, ok := cfg.PeerOptions[peer.PublicKey]; ok && len(opts.FallbackEndpoints) > 0 && peer.Endpoint == nil {
+			v.warnf(i, "FallbackEndpoint", "fallback endpoints without Endpoint are never used")
+		}
+	}
+
+	// overlapping, but not identical AllowedIPs are legal (the more specific wins), though often a mistake
+	overlaps := allowedIPsOverlaps(cfg.Peers)
+	pairs := overlappingPairs(overlaps)
+	for _, pair := range pairs {
+		v.warnf(pair[1], "AllowedIPs", "overlaps with peer #%d AllowedIPs on %s", pair[0]+1, FormatCIDRs(AggregateCIDRs(overlaps[pair])))
+	}
+	return v
+}
+
+// peerPrefix is AllowedIPs prefix of the peer with the given index
+type peerPrefix struct {
+	network net.IPNet
+	peer    int
+}
+
+// allowedIPsOverlaps returns the overlapping AllowedIPs between pairs of peers, keyed by the peer indexes in ascending order.
+// Identical prefixes aren't included. CIDR prefixes either nest or are disjoint, so after sorting by address and prefix length
+// every prefix overlaps exactly with the prefixes enclosing it, which a single sweep keeps on a stack
+func allowedIPsOverlaps(peers []wgtypes.PeerConfig) map[[2]int][]net.IPNet {
+	var prefixes []peerPrefix
+	for i, peer := range peers {
+		for _, allowed := range peer.AllowedIPs {
+			ip := allowed.IP.Mask(allowed.Mask)
+			if ip4 := ip.To4(); ip4 != nil && len(allowed.Mask) == net.IPv4len {
+				ip = ip4
+			}
+			prefixes = append(prefixes, peerPrefix{network: net.IPNet{IP: ip, Mask: allowed.Mask}, peer: i})
+		}
+	}
+	sort.Slice(prefixes, func(a, b int) bool {
+		na, nb := prefixes[a].network, prefixes[b].network
+		if len(na.IP) != len(nb.IP) {
+			return len(na.IP) < len(nb.IP)
+		}
+		if c := bytes.Compare(na.IP, nb.IP); c != 0 {
+			return c < 0
+		}
+		onesA, _ := na.Mask.Size()
+		onesB, _ := nb.Mask.Size()
+		return onesA < onesB
+	})
+
+	overlaps := make(map[[2]int][]net.IPNet)
+	var enclosing []peerPrefix
+	for _, p := range prefixes {
+		for len(enclosing) > 0 && !containsNetwork(enclosing[len(enclosing)-1].network, p.network) {
+			enclosing = enclosing[:len(enclosing)-1]
+		}
+		for _, outer := range enclosing {
+			if outer.peer == p.peer || sameNetwork(outer.network, p.network) {
+				continue // identical prefixes are reported as error already
+			}
+			pair := [2]int{outer.peer, p.peer}
+			if pair[0] > pair[1] {
+				pair[0], pair[1] = pair[1], pair[0]
+			}
+			overlaps[pair] = append(overlaps[pair], p.network)
+		}
+		enclosing = append(enclosing, p)
+	}
+	return overlaps
+}
+
+// overlappingPairs returns the peer index pairs of allowedIPsOverlaps result in ascending order
+func overlappingPairs(overlaps map[[2]int][]net.IPNet) [][2]int {
+	pairs := make([][2]int, 0, len(overlaps))
+	for pair := range overlaps {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(a, b int) bool {
+		if pairs[a][0] != pairs[b][0] {
+			return pairs[a][0] < pairs[b][0]
+		}
+		return pairs[a][1] < pairs[b][1]
+	})
+	return pairs
+}
+
+// containsNetwork reports whether the outer network contains the inner one
+func containsNetwork(outer, inner net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return len(outer.IP) == len(inner.IP) && outerBits == innerBits && outerOnes <= innerOnes && inner.IP.Mask(outer.Mask).Equal(outer.IP)
+}
+
+func sameNetwork(a, b net.IPNet) bool {
+	aOnes, aBits := a.Mask.Size()
+	bOnes, bBits := b.Mask.Size()
+	return aOnes == bOnes && aBits == bBits && a.IP.Mask(a.Mask).Equal(b.IP.Mask(b.Mask))
+}
+
+// validate runs Validate, logging the warnings and returning the errors
+func validate(cfg *Config, log logrus.FieldLogger) error {
+	v := cfg.Validate()
+	for _, w := range v.Warnings {
+		log.Warn(w.String())
+	}
+	return v.Err()
+}
@@ -0,0 +1,68 @@
+package wgquick
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestValidate(t *testing.T) {
+	for name, text := range testConfigs {
+		t.Run(name, func(t *testing.T) {
+			c := &Config{}
+			require.NoError(t, c.UnmarshalText([]byte(text)))
+			v := c.Validate()
+			assert.NoError(t, v.Err())
+			assert.Empty(t, v.Warnings)
+		})
+	}
+
+	c := &Config{}
+	require.NoError(t, c.UnmarshalText([]byte(`[Interface]
+Address = 10.0.0.0/24
+ListenPort = 70000
+
+[Peer]
+PublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+AllowedIPs = 10.0.0.0/24
+
+[Peer]
+PublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+AllowedIPs = 10.0.0.0/24, 10.0.0.128/25, 10.1.0.0/16
+`)))
+	v := c.Validate()
+	var errs, warnings []string
+	for _, p := range v.Errors {
+		errs = append(errs, p.String())
+	}
+	for _, p := range v.Warnings {
+		warnings = append(warnings, p.String())
+	}
+	assert.Equal(t, []string{
+		"[Interface] PrivateKey: missing private key",
+		"[Interface] ListenPort: port 70000 out of range 0-65535",
+		"[Interface] Address: 10.0.0.0/24 has no host bits, it's the network address",
+		"[Peer #2] PublicKey: duplicate public key GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=, first used by peer #1",
+		"[Peer #2] AllowedIPs: 10.0.0.0/24 is already allowed for peer #1, only one peer gets it",
+	}, errs)
+	assert.Equal(t, []string{"[Peer #2] AllowedIPs: overlaps with peer #1 AllowedIPs on 10.0.0.128/25"}, warnings)
+	assert.IsType(t, ValidationError{}, v.Err())
+}
+
+func TestAllowedIPsOverlaps(t *testing.T) {
+	peers := []wgtypes.PeerConfig{
+		{AllowedIPs: mustCIDRs(t, "10.0.1.0/24, fd00::/64")},
+		{AllowedIPs: mustCIDRs(t, "10.0.1.0/24, 10.0.2.0/24, 10.0.2.128/25")},
+		{AllowedIPs: mustCIDRs(t, "10.0.0.0/16, fd00::1/128, 192.168.1.0/24")},
+	}
+	overlaps := make(map[[2]int]string)
+	for pair, nets := range allowedIPsOverlaps(peers) {
+		overlaps[pair] = FormatCIDRs(nets)
+	}
+	assert.Equal(t, map[[2]int]string{
+		{0, 2}: "10.0.1.0/24, fd00::1/128",
+		{1, 2}: "10.0.1.0/24, 10.0.2.0/24, 10.0.2.128/25",
+	}, overlaps, "identical prefixes and the peer's own prefixes are skipped")
+}
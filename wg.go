@@ -3,28 +3,45 @@ package wgquick
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 // Up sets and configures the wg interface. Mostly equivalent to `wg-quick up iface`
+// The config is validated before touching the system, see Config.Validate
 func Up(cfg *Config, iface string, logger logrus.FieldLogger) error {
 	log := logger.WithField("iface", iface)
-	_, err := netlink.LinkByName(iface)
+	if err := validate(cfg, log); err != nil {
+		return err
+	}
+	lock, err := lockInterface(iface, "up", lockTimeout(cfg))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	_, err = netlink.LinkByName(iface)
 	if err == nil {
 		return os.ErrExist
 	}
 	if _, ok := err.(netlink.LinkNotFoundError); !ok {
 		return err
 	}
+	if err := RemoveState(iface); err != nil {
+		return err
+	}
 
 	for _, dns := range cfg.DNS {
 		if err := execSh("resolvconf -a tun.%i -m 0 -x", iface, log, fmt.Sprintf("nameserver %s\n", dns)); err != nil {
@@ -38,7 +55,7 @@ func Up(cfg *Config, iface string, logger logrus.FieldLogger) error {
 		}
 		log.Infoln("applied pre-up command")
 	}
-	if err := Sync(cfg, iface, logger); err != nil {
+	if err := syncLocked(cfg, iface, logger); err != nil {
 		return err
 	}
 
@@ -54,6 +71,12 @@ func Up(cfg *Config, iface string, logger logrus.FieldLogger) error {
 // Down destroys the wg interface. Mostly equivalent to `wg-quick down iface`
 func Down(cfg *Config, iface string, logger logrus.FieldLogger) error {
 	log := logger.WithField("iface", iface)
+	lock, err := lockInterface(iface, "down", lockTimeout(cfg))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
 	link, err := netlink.LinkByName(iface)
 	if err != nil {
 		return err
@@ -72,10 +95,18 @@ func Down(cfg *Config, iface string, logger logrus.FieldLogger) error {
 		log.Infoln("applied pre-down command")
 	}
 
-	if err := netlink.LinkDel(link); err != nil {
-		return err
+	if stopUserspace(iface) {
+		log.Infoln("userspace device stopped")
+	} else {
+		if err := netlink.LinkDel(link); err != nil {
+			return err
+		}
+		log.Infoln("link deleted")
+	}
+	removeUAPISocket(iface, log)
+	if err := RemoveState(iface); err != nil {
+		log.WithError(err).Warn("cannot remove interface state")
 	}
-	log.Infoln("link deleted")
 	if cfg.PostDown != "" {
 		if err := execSh(cfg.PostDown, iface, log); err != nil {
 			return err
@@ -109,62 +140,240 @@ func execSh(command string, iface string, log logrus.FieldLogger, stdin ...strin
 // Sync the config to the current setup for given interface
 // It perform 4 operations:
 // * SyncLink --> makes sure link is up and type wireguard
+// * SyncForwarding --> enables forwarding on the interface, if cfg.Forwarding is set
 // * SyncWireguardDevice --> configures allowedIP & other wireguard specific settings
 // * SyncAddress --> synces linux addresses bounded to this interface
-// * SyncRoutes --> synces all allowedIP routes to route to this interface
+// * SyncRoutes --> synces all allowedIP routes to route to this interface, except for peers failing their HealthCheck
+// Keys pending after Rotate are configured once their PendingKeysAt has passed.
+// The config is validated first, see Config.Validate. The interface lock is held for the duration of the sync
 func Sync(cfg *Config, iface string, logger logrus.FieldLogger) error {
+	if err := validate(cfg, logger.WithField("iface", iface)); err != nil {
+		return err
+	}
+	lock, err := lockInterface(iface, "sync", lockTimeout(cfg))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	return syncLocked(cfg, iface, logger)
+}
+
+func syncLocked(cfg *Config, iface string, logger logrus.FieldLogger) error {
+	changes, err := syncDevice(cfg, iface, logger)
+	notifySync(iface, changes, err)
+	return err
+}
+
+func syncDevice(cfg *Config, iface string, logger logrus.FieldLogger) (*DeviceChanges, error) {
 	log := logger.WithField("iface", iface)
+	now := time.Now()
+	switch {
+	case cfg.PendingKeysOverdue(now):
+		log.WithField("due", cfg.PendingKeysAt).Warn("pending keys overdue, configuring them now. Remote peers switched at the due time, commit the keys with wg-quick commit-keys")
+	case !cfg.PendingKeysAt.IsZero():
+		log.WithField("at", cfg.PendingKeysAt).Warn("keys pending, run wg-quick -wait commit-keys here and on the remote peers to switch at that time")
+	}
+	cfg = cfg.withDueKeys(now).withExcludedIPs()
 
 	link, err := SyncLink(cfg, iface, log)
 	if err != nil {
 		log.WithError(err).Errorln("cannot sync wireguard link")
-		return err
+		return nil, err
 	}
 	log.Info("synced link")
 
-	if err := SyncWireguardDevice(cfg, link, log); err != nil {
+	if cfg.Forwarding {
+		if err := SyncForwarding(iface, log); err != nil {
+			log.WithError(err).Errorln("cannot enable forwarding")
+			return nil, err
+		}
+	}
+
+	changes, err := SyncWireguardDevice(cfg, link, log)
+	if err != nil {
 		log.WithError(err).Errorln("cannot sync wireguard link")
-		return err
+		return nil, err
 	}
-	log.Info("synced link")
+	log.WithFields(map[string]interface{}{
+		"added_peers":   len(changes.Added),
+		"updated_peers": len(changes.Updated),
+		"removed_peers": len(changes.Removed),
+	}).Info("synced wireguard device")
 
 	if err := SyncAddress(cfg, link, log); err != nil {
 		log.WithError(err).Errorln("cannot sync addresses")
-		return err
+		return changes, err
 	}
 	log.Info("synced addresss")
 
-	var managedRoutes []net.IPNet
-	for _, peer := range cfg.Peers {
-		for _, rt := range peer.AllowedIPs {
-			managedRoutes = append(managedRoutes, rt)
-		}
+	st, err := LoadState(iface)
+	if err != nil {
+		log.WithError(err).Errorln("cannot read interface state")
+		return changes, err
 	}
-	if err := SyncRoutes(cfg, link, managedRoutes, log); err != nil {
+	if err := SyncRoutes(cfg, link, routedAllowedIPs(cfg, st), log); err != nil {
 		log.WithError(err).Errorln("cannot sync routes")
-		return err
+		return changes, err
 	}
 	log.Info("synced routed")
 	log.Info("Successfully synced device")
-	return nil
+	return changes, nil
+}
+
+// DeviceChanges reports the peer changes SyncWireguardDevice made on the device
+type DeviceChanges struct {
+	// Added peers weren't present on the device
+	Added []wgtypes.Key
+	// Updated peers were present on the device, but some of their settings differed from the config
+	Updated []wgtypes.Key
+	// Removed peers were present on the device, but not in the config
+	Removed []wgtypes.Key
+}
 
+// Empty reports whether no peer was changed
+func (c *DeviceChanges) Empty() bool {
+	return len(c.Added)+len(c.Updated)+len(c.Removed) == 0
 }
 
 // SyncWireguardDevice synces wireguard vpn setting on the given link. It does not set routes/addresses beyond wg internal crypto-key routing, only handles wireguard specific settings
-func SyncWireguardDevice(cfg *Config, link netlink.Link, log logrus.FieldLogger) error {
+// The config is diffed against the device and only changed settings are sent. Peers missing from the config are removed, others keep their handshakes.
+// Peer endpoint is only set when the config value changed since the last sync, so endpoints learned from roaming are kept
+func SyncWireguardDevice(cfg *Config, link netlink.Link, log logrus.FieldLogger) (*DeviceChanges, error) {
+	iface := link.Attrs().Name
+	st, err := LoadState(iface)
+	if err != nil {
+		log.WithError(err).Error("cannot read interface state")
+		return nil, err
+	}
+
 	cl, err := wgctrl.New()
 	if err != nil {
 		log.WithError(err).Errorln("cannot setup wireguard device")
-		return err
+		return nil, err
 	}
-	if err := cl.ConfigureDevice(link.Attrs().Name, cfg.Config); err != nil {
-		log.WithError(err).Error("cannot configure device")
-		return err
+	defer cl.Close()
+
+	dev, err := cl.Device(iface)
+	if err != nil {
+		log.WithError(err).Error("cannot read device")
+		return nil, err
 	}
-	return nil
+
+	wgCfg, changes := diffDevice(cfg, dev, st.Endpoints)
+	if wgCfg.PrivateKey != nil || wgCfg.ListenPort != nil || len(wgCfg.Peers) > 0 {
+		if err := cl.ConfigureDevice(iface, wgCfg); err != nil {
+			log.WithError(err).Error("cannot configure device")
+			return nil, err
+		}
+	}
+	for _, key := range changes.Added {
+		log.WithField("peer", key.String()).Info("added peer")
+	}
+	for _, key := range changes.Updated {
+		log.WithField("peer", key.String()).Info("updated peer")
+	}
+	for _, key := range changes.Removed {
+		log.WithField("peer", key.String()).Info("removed peer not present in config")
+	}
+
+	st.Endpoints = make(map[string]string)
+	for _, peer := range cfg.Peers {
+		if peer.Endpoint != nil {
+			st.Endpoints[peer.PublicKey.String()] = peer.Endpoint.String()
+		}
+	}
+	if err := st.Save(iface); err != nil {
+		log.WithError(err).Error("cannot save interface state")
+		return nil, err
+	}
+	return changes, nil
+}
+
+// diffDevice computes minimal wgtypes.Config bringing dev to the cfg. appliedEndpoints are config endpoints (by peer public key) from the last sync
+func diffDevice(cfg *Config, dev *wgtypes.Device, appliedEndpoints map[string]string) (wgtypes.Config, *DeviceChanges) {
+	wgCfg := wgtypes.Config{}
+	changes := &DeviceChanges{}
+	if cfg.PrivateKey != nil && *cfg.PrivateKey != dev.PrivateKey {
+		wgCfg.PrivateKey = cfg.PrivateKey
+	}
+	if cfg.ListenPort != nil && *cfg.ListenPort != dev.ListenPort {
+		wgCfg.ListenPort = cfg.ListenPort
+	}
+	if cfg.FirewallMark != nil && *cfg.FirewallMark != dev.FirewallMark {
+		wgCfg.FirewallMark = cfg.FirewallMark
+	}
+
+	current := make(map[wgtypes.Key]wgtypes.Peer, len(dev.Peers))
+	for _, peer := range dev.Peers {
+		current[peer.PublicKey] = peer
+	}
+
+	wanted := make(map[wgtypes.Key]bool, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		wanted[peer.PublicKey] = true
+		cur, ok := current[peer.PublicKey]
+		if !ok {
+			peer.ReplaceAllowedIPs = true
+			wgCfg.Peers = append(wgCfg.Peers, peer)
+			changes.Added = append(changes.Added, peer.PublicKey)
+			continue
+		}
+		if diff, changed := diffPeer(peer, cur, appliedEndpoints[peer.PublicKey.String()]); changed {
+			wgCfg.Peers = append(wgCfg.Peers, diff)
+			changes.Updated = append(changes.Updated, peer.PublicKey)
+		}
+	}
+
+	for _, peer := range dev.Peers {
+		if !wanted[peer.PublicKey] {
+			wgCfg.Peers = append(wgCfg.Peers, wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+			changes.Removed = append(changes.Removed, peer.PublicKey)
+		}
+	}
+	return wgCfg, changes
+}
+
+// diffPeer returns update only peer config with settings differing between desired and current peer
+func diffPeer(desired wgtypes.PeerConfig, current wgtypes.Peer, appliedEndpoint string) (wgtypes.PeerConfig, bool) {
+	diff := wgtypes.PeerConfig{PublicKey: desired.PublicKey, UpdateOnly: true}
+	changed := false
+
+	var psk wgtypes.Key
+	if desired.PresharedKey != nil {
+		psk = *desired.PresharedKey
+	}
+	if psk != current.PresharedKey {
+		diff.PresharedKey = &psk
+		changed = true
+	}
+
+	var keepalive time.Duration
+	if desired.PersistentKeepaliveInterval != nil {
+		keepalive = *desired.PersistentKeepaliveInterval
+	}
+	if keepalive != current.PersistentKeepaliveInterval {
+		diff.PersistentKeepaliveInterval = &keepalive
+		changed = true
+	}
+
+	if FormatCIDRs(AggregateCIDRs(desired.AllowedIPs)) != FormatCIDRs(AggregateCIDRs(current.AllowedIPs)) {
+		diff.ReplaceAllowedIPs = true
+		diff.AllowedIPs = desired.AllowedIPs
+		changed = true
+	}
+
+	if desired.Endpoint != nil && (current.Endpoint == nil || current.Endpoint.String() != desired.Endpoint.String()) {
+		// keep the endpoint learned from roaming, unless the config value itself changed
+		if current.Endpoint == nil || desired.Endpoint.String() != appliedEndpoint {
+			diff.Endpoint = desired.Endpoint
+			changed = true
+		}
+	}
+	return diff, changed
 }
 
 // SyncLink synces link state with the config. It does not sync Wireguard settings, just makes sure the device is up and type wireguard
+// (or TUN device driven by userspace wireguard-go, see Config.Userspace)
 func SyncLink(cfg *Config, iface string, log logrus.FieldLogger) (netlink.Link, error) {
 	link, err := netlink.LinkByName(iface)
 	if err != nil {
@@ -173,14 +382,7 @@ func SyncLink(cfg *Config, iface string, log logrus.FieldLogger) (netlink.Link,
 			return nil, err
 		}
 		log.Info("link not found, creating")
-		wgLink := &netlink.GenericLink{
-			LinkAttrs: netlink.LinkAttrs{
-				Name: iface,
-				MTU:  cfg.MTU,
-			},
-			LinkType: "wireguard",
-		}
-		if err := netlink.LinkAdd(wgLink); err != nil {
+		if err := createLink(cfg, iface, log); err != nil {
 			log.WithError(err).Error("cannot create link")
 			return nil, err
 		}
@@ -199,9 +401,67 @@ func SyncLink(cfg *Config, iface string, log logrus.FieldLogger) (netlink.Link,
 	return link, nil
 }
 
-// SyncAddress adds/deletes all lind assigned IPV4 addressed as specified in the config
+// procSys is the root of the network sysctls
+const procSys = "/proc/sys/net"
+
+// SyncForwarding enables IPv4 forwarding for packets received on the interface. Linux has no per interface IPv6 forwarding,
+// the interface setting only switches it between host and router behaviour, so IPv6 forwarding is enabled system wide.
+// Forwarding isn't disabled when not configured, since it may be enabled by the system configuration
+func SyncForwarding(iface string, log logrus.FieldLogger) error {
+	if err := ioutil.WriteFile(filepath.Join(procSys, "ipv4", "conf", iface, "forwarding"), []byte("1\n"), 0644); err != nil {
+		return err
+	}
+	path := filepath.Join(procSys, "ipv6", "conf", "all", "forwarding")
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		// IPv6 disabled on the system
+		log.Info("enabled forwarding")
+		return nil
+	case err != nil:
+		return err
+	}
+	if strings.TrimSpace(string(b)) != "1" {
+		if err := ioutil.WriteFile(path, []byte("1\n"), 0644); err != nil {
+			return err
+		}
+		log.Warn("enabled IPv6 forwarding on all interfaces, those with accept_ra=1 stop accepting router advertisements")
+	}
+	log.Info("enabled forwarding")
+	return nil
+}
+
+// createLink creates kernel wireguard link, or userspace wireguard-go device depending on cfg.Userspace
+func createLink(cfg *Config, iface string, log logrus.FieldLogger) error {
+	if cfg.Userspace == UserspaceAlways {
+		return startUserspace(iface, cfg.MTU, log)
+	}
+	wgLink := &netlink.GenericLink{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: iface,
+			MTU:  cfg.MTU,
+		},
+		LinkType: "wireguard",
+	}
+	err := netlink.LinkAdd(wgLink)
+	if err != nil && cfg.Userspace == UserspaceAuto && isKernelUnsupported(err) {
+		log.WithError(err).Warn("kernel doesn't support wireguard, falling back to userspace wireguard-go")
+		return startUserspace(iface, cfg.MTU, log)
+	}
+	return err
+}
+
+// SyncAddress adds/deletes link assigned addresses as specified in the config.
+// Only addresses this library added are deleted (see State), unless cfg.Exclusive is set, in which case every IPv4 address not in the config is deleted
 func SyncAddress(cfg *Config, link netlink.Link, log logrus.FieldLogger) error {
-	addrs, err := netlink.AddrList(link, syscall.AF_INET)
+	iface := link.Attrs().Name
+	st, err := LoadState(iface)
+	if err != nil {
+		log.WithError(err).Error("cannot read interface state")
+		return err
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		log.Error(err, "cannot read link address")
 		return err
@@ -217,11 +477,15 @@ func SyncAddress(cfg *Config, link netlink.Link, log logrus.FieldLogger) error {
 		presentAddresses[addr.IPNet.String()] = addr
 	}
 
+	var owned []string
 	for _, addr := range cfg.Address {
 		log := log.WithField("addr", addr.String())
 		_, present := presentAddresses[addr.String()]
 		presentAddresses[addr.String()] = netlink.Addr{} // mark as present
 		if present {
+			if st.ownsAddress(&addr) {
+				owned = append(owned, addr.String())
+			}
 			log.Info("address present")
 			continue
 		}
@@ -234,6 +498,7 @@ func SyncAddress(cfg *Config, link netlink.Link, log logrus.FieldLogger) error {
 				return err
 			}
 		}
+		owned = append(owned, addr.String())
 		log.Info("address added")
 	}
 
@@ -245,12 +510,22 @@ func SyncAddress(cfg *Config, link netlink.Link, log logrus.FieldLogger) error {
 			"addr":  addr.IPNet.String(),
 			"label": addr.Label,
 		})
+		if !st.ownsAddress(addr.IPNet) && !(cfg.Exclusive && addr.IP.To4() != nil) {
+			log.Debug("skipping addr deletion, not owned by this daemon")
+			continue
+		}
 		if err := netlink.AddrDel(link, &addr); err != nil {
 			log.WithError(err).Error("cannot delete addr")
 			return err
 		}
 		log.Info("addr deleted")
 	}
+
+	st.Addresses = owned
+	if err := st.Save(iface); err != nil {
+		log.WithError(err).Error("cannot save interface state")
+		return err
+	}
 	return nil
 }
 
@@ -267,12 +542,63 @@ func fillRouteDefaults(rt *netlink.Route) {
 	if rt.Type == 0 {
 		rt.Type = unix.RTN_UNICAST
 	}
+
+	rt.Priority = routePriority(*rt)
+}
+
+// ip6DefaultRoutePriority is the metric the kernel assigns to IPv6 routes added without one
+const ip6DefaultRoutePriority = 1024
+
+// routePriority returns the route metric as the kernel reports it back
+func routePriority(rt netlink.Route) int {
+	if rt.Priority == 0 && rt.Dst != nil && rt.Dst.IP.To4() == nil {
+		return ip6DefaultRoutePriority
+	}
+	return rt.Priority
+}
+
+// managedRoute is the route this library installs for the given destination
+func managedRoute(cfg *Config, link netlink.Link, dst net.IPNet) netlink.Route {
+	// kernel reports the route destination without host bits, e.g. AllowedIPs = 10.0.0.1/24 is routed as 10.0.0.0/24
+	dst = net.IPNet{IP: dst.IP.Mask(dst.Mask), Mask: dst.Mask}
+	rt := netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       &dst,
+		Table:     cfg.Table,
+		Protocol:  cfg.RouteProtocol,
+		Priority:  cfg.routeMetric(dst)}
+	fillRouteDefaults(&rt)
+	return rt
+}
+
+// routeMetric returns RouteMetric of the peer having dst in AllowedIPs, falling back to the interface RouteMetric
+func (cfg *Config) routeMetric(dst net.IPNet) int {
+	for _, peer := range cfg.Peers {
+		opts, ok := cfg.PeerOptions[peer.PublicKey]
+		if !ok || opts.RouteMetric == 0 {
+			continue
+		}
+		for _, allowed := range cfg.allowedIPs(peer) {
+			if sameNetwork(allowed, dst) {
+				return opts.RouteMetric
+			}
+		}
+	}
+	return cfg.RouteMetric
 }
 
-// SyncRoutes adds/deletes all route assigned IPV4 addressed as specified in the config
+// SyncRoutes adds/deletes all routes for the managedRoutes destinations.
+// Only routes this library added are deleted (see State), unless cfg.Exclusive is set, in which case every IPv4 route in the configured table with the configured protocol is deleted if unwanted
 func SyncRoutes(cfg *Config, link netlink.Link, managedRoutes []net.IPNet, log logrus.FieldLogger) error {
+	iface := link.Attrs().Name
+	st, err := LoadState(iface)
+	if err != nil {
+		log.WithError(err).Error("cannot read interface state")
+		return err
+	}
+
 	var wantedRoutes = make(map[string][]netlink.Route, len(managedRoutes))
-	presentRoutes, err := netlink.RouteList(link, syscall.AF_INET)
+	presentRoutes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		log.Error(err, "cannot read existing routes")
 		return err
@@ -281,16 +607,11 @@ func SyncRoutes(cfg *Config, link netlink.Link, managedRoutes []net.IPNet, log l
 		rt := rt // make copy
 		log.WithField("dst", rt.String()).Debug("managing route")
 
-		nrt := netlink.Route{
-			LinkIndex: link.Attrs().Index,
-			Dst:       &rt,
-			Table:     cfg.Table,
-			Protocol:  cfg.RouteProtocol,
-			Priority:  cfg.RouteMetric}
-		fillRouteDefaults(&nrt)
-		wantedRoutes[rt.String()] = append(wantedRoutes[rt.String()], nrt)
+		nrt := managedRoute(cfg, link, rt)
+		wantedRoutes[nrt.Dst.String()] = append(wantedRoutes[nrt.Dst.String()], nrt)
 	}
 
+	var owned []StateRoute
 	for _, rtLst := range wantedRoutes {
 		for _, rt := range rtLst {
 			rt := rt // make copy
@@ -305,6 +626,7 @@ func SyncRoutes(cfg *Config, link netlink.Link, managedRoutes []net.IPNet, log l
 				log.WithError(err).Errorln("cannot add/replace route")
 				return err
 			}
+			owned = append(owned, stateRoute(rt))
 			log.Infoln("route added/replaced")
 		}
 	}
@@ -318,7 +640,14 @@ func SyncRoutes(cfg *Config, link netlink.Link, managedRoutes []net.IPNet, log l
 		return false
 	}
 
+	// the defaults routes were created with, used to recognise our routes in exclusive mode
+	ours := netlink.Route{Table: cfg.Table, Protocol: cfg.RouteProtocol}
+	fillRouteDefaults(&ours)
+
 	for _, rt := range presentRoutes {
+		if rt.Dst == nil {
+			continue
+		}
 		log := log.WithFields(map[string]interface{}{
 			"route":    rt.Dst.String(),
 			"protocol": rt.Protocol,
@@ -326,14 +655,19 @@ func SyncRoutes(cfg *Config, link netlink.Link, managedRoutes []net.IPNet, log l
 			"type":     rt.Type,
 			"metric":   rt.Priority,
 		})
-		if !(rt.Table == cfg.Table || (cfg.Table == 0 && rt.Table == unix.RT_CLASS_MAIN)) {
-			log.Debug("wrong table for route, skipping")
-			continue
-		}
-
-		if !(rt.Protocol == cfg.RouteProtocol) {
-			log.Infof("skipping route deletion, not owned by this daemon")
-			continue
+		if !st.ownsRoute(rt) {
+			if !cfg.Exclusive || rt.Dst.IP.To4() == nil {
+				log.Debug("skipping route deletion, not owned by this daemon")
+				continue
+			}
+			if rt.Table != ours.Table {
+				log.Debug("wrong table for route, skipping")
+				continue
+			}
+			if rt.Protocol != ours.Protocol {
+				log.Infof("skipping route deletion, not owned by this daemon")
+				continue
+			}
 		}
 
 		if checkWanted(rt) {
@@ -348,5 +682,10 @@ func SyncRoutes(cfg *Config, link netlink.Link, managedRoutes []net.IPNet, log l
 		log.Info("route deleted")
 	}
 
+	st.Routes = owned
+	if err := st.Save(iface); err != nil {
+		log.WithError(err).Error("cannot save interface state")
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,75 @@
+package wgquick
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func mustKey(t *testing.T) wgtypes.Key {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	return key.PublicKey()
+}
+
+func mustCIDRs(t *testing.T, s string) []net.IPNet {
+	nets, err := ParseCIDRs(s)
+	require.NoError(t, err)
+	return nets
+}
+
+func TestDiffDevice(t *testing.T) {
+	unchanged, moved, roamed, revoked, added := mustKey(t), mustKey(t), mustKey(t), mustKey(t), mustKey(t)
+	keepalive := 25 * time.Second
+	cfgEndpoint := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 51820}
+	roamedEndpoint := &net.UDPAddr{IP: net.ParseIP("5.6.7.8"), Port: 1234}
+
+	cfg := &Config{Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{
+		{PublicKey: unchanged, AllowedIPs: mustCIDRs(t, "10.0.0.2/32"), PersistentKeepaliveInterval: &keepalive},
+		{PublicKey: moved, AllowedIPs: mustCIDRs(t, "10.0.0.3/32, 10.1.0.0/16")},
+		{PublicKey: roamed, AllowedIPs: mustCIDRs(t, "10.0.0.4/32"), Endpoint: cfgEndpoint},
+		{PublicKey: added, AllowedIPs: mustCIDRs(t, "10.0.0.5/32")},
+	}}}
+	dev := &wgtypes.Device{Peers: []wgtypes.Peer{
+		{PublicKey: unchanged, AllowedIPs: mustCIDRs(t, "10.0.0.2/32"), PersistentKeepaliveInterval: keepalive},
+		{PublicKey: moved, AllowedIPs: mustCIDRs(t, "10.0.0.3/32")},
+		{PublicKey: roamed, AllowedIPs: mustCIDRs(t, "10.0.0.4/32"), Endpoint: roamedEndpoint},
+		{PublicKey: revoked, AllowedIPs: mustCIDRs(t, "10.0.0.6/32")},
+	}}
+	applied := map[string]string{roamed.String(): cfgEndpoint.String()}
+
+	wgCfg, changes := diffDevice(cfg, dev, applied)
+	assert.Equal(t, []wgtypes.Key{added}, changes.Added)
+	assert.Equal(t, []wgtypes.Key{moved}, changes.Updated)
+	assert.Equal(t, []wgtypes.Key{revoked}, changes.Removed)
+	assert.False(t, wgCfg.ReplacePeers)
+	require.Len(t, wgCfg.Peers, 3)
+	assert.True(t, wgCfg.Peers[0].UpdateOnly)
+	assert.True(t, wgCfg.Peers[0].ReplaceAllowedIPs)
+	assert.Nil(t, wgCfg.Peers[0].Endpoint)
+	assert.Equal(t, added, wgCfg.Peers[1].PublicKey)
+	assert.True(t, wgCfg.Peers[2].Remove)
+
+	// endpoint changed in the config
+	applied[roamed.String()] = "9.9.9.9:51820"
+	wgCfg, changes = diffDevice(cfg, dev, applied)
+	assert.Equal(t, []wgtypes.Key{moved, roamed}, changes.Updated)
+	assert.Equal(t, cfgEndpoint, wgCfg.Peers[1].Endpoint)
+	assert.Nil(t, wgCfg.Peers[1].PresharedKey)
+	assert.False(t, wgCfg.Peers[1].ReplaceAllowedIPs)
+	assert.Nil(t, wgCfg.FirewallMark)
+
+	// fwmark changed in the config
+	fwmark := 51820
+	cfg.FirewallMark = &fwmark
+	wgCfg, _ = diffDevice(cfg, dev, applied)
+	require.NotNil(t, wgCfg.FirewallMark)
+	assert.Equal(t, 51820, *wgCfg.FirewallMark)
+	dev.FirewallMark = 51820
+	wgCfg, _ = diffDevice(cfg, dev, applied)
+	assert.Nil(t, wgCfg.FirewallMark)
+}